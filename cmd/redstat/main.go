@@ -3,11 +3,10 @@ package main
 // redstat is a 'stat' command for RED, requests, errors and duration
 
 import (
-	r "../../pkg/red"
 	"flag"
 	"fmt"
+	r "github.com/davecb/RED/pkg/red"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	u "net/url"
@@ -71,7 +70,9 @@ func main() {
 // if count is absent, a continuous series of values are returned
 // assumes duration is in wall-clock time
 func redstat(url string, delay, count int, verbose, json, crash bool) *r.Red {
-	var first, second, difference *r.Red
+	var first, second r.Sample
+	var difference *r.Red
+	var restarted bool
 	var err error
 
 	// get the first query
@@ -86,8 +87,8 @@ func redstat(url string, delay, count int, verbose, json, crash bool) *r.Red {
 			}
 			log.Fatalf("redstat: fatal error, halting. Message was %q\n", err)
 		}
-		report(first, json) // duration will be (now - program start time)
-		return first        // Used in testing
+		report(first.Red, json, false) // duration will be (now - program start time)
+		return first.Red               // Used in testing
 	case delay != -1:
 		// wait, subtract and report the differences
 		first, err = getRed(url, verbose)
@@ -111,11 +112,14 @@ func redstat(url string, delay, count int, verbose, json, crash bool) *r.Red {
 				log.Fatalf("redstat: fatal error, halting. Message was %q\n", err)
 			}
 			if verbose {
-				log.Printf("Subsequent sample %d was %s\n", i, first.String())
+				log.Printf("Subsequent sample %d was %s\n", i, second.String())
 			}
-			difference = second.Subtract(first)
-			difference.Duration = tick // set the requested duration
-			report(difference, json)   // and report it
+			difference, restarted = delta(first, second)
+			if restarted && verbose {
+				log.Printf("the server restarted between samples %d and %d\n", i-1, i)
+			}
+			difference.Duration = tick          // set the requested duration
+			report(difference, json, restarted) // and report it
 			first = second
 			// check for ^C here
 			if i == count-1 {
@@ -126,22 +130,72 @@ func redstat(url string, delay, count int, verbose, json, crash bool) *r.Red {
 	return difference // last one, for testing
 }
 
-// report produces human-oriented or json output
-func report(r *r.Red, json bool) {
+// delta is the change in the counts from first to second. If the server
+// restarted in between, its counts started again from zero, so the change
+// is what it's counted since, and restarted is true. A restart shows as
+// counts going down, or, from servers that send the wire format, as a new
+// instance or epoch. If the server restored its counts from a checkpoint,
+// they're its totals, not what it's counted since, so the change is
+// their difference, or zero where they went down because the server
+// stopped before saving them.
+func delta(first, second r.Sample) (difference *r.Red, restarted bool) {
+	a, b := first.Red, second.Red
+	wentDown := b.Requests < a.Requests || b.Errors < a.Errors
+	newProcess := first.Version > 0 && second.Version > 0 &&
+		(first.Instance != second.Instance || !first.Epoch.Equal(second.Epoch))
+	carried := newProcess && !second.Restored.IsZero()
+
+	switch {
+	case carried:
+		return &r.Red{
+			Requests: atLeastZero(b.Requests - a.Requests),
+			Errors:   atLeastZero(b.Errors - a.Errors),
+		}, true
+	case wentDown || newProcess:
+		return &r.Red{Requests: b.Requests, Errors: b.Errors, Duration: b.Duration}, true
+	default:
+		return &r.Red{
+			Requests: b.Requests - a.Requests,
+			Errors:   b.Errors - a.Errors,
+			Duration: b.Duration - a.Duration,
+		}, false
+	}
+}
+
+// atLeastZero clamps a count that went down to zero
+func atLeastZero(n int64) int64 {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// report produces human-oriented or json output, marking the
+// intervals in which the server restarted
+func report(r *r.Red, json, restarted bool) {
+	mark := ""
+	if restarted {
+		mark = " (restart)"
+	}
 	if json {
 		s, _ := r.MarshalJSON() // correct by construction
-		fmt.Printf("red = %s", s)
+		fmt.Printf("red = %s%s", s, mark)
 	} else {
-		fmt.Printf("red = %s\n", r.String())
+		fmt.Printf("red = %s%s\n", r.String(), mark)
 	}
 }
 
 // getRed gets a datum, stopping or panicking on error
-func getRed(url string, verbose bool) (*r.Red, error) {
-	var red, zero *r.Red
+func getRed(url string, verbose bool) (r.Sample, error) {
+	zero := r.Sample{Red: r.Start()}
 
-	zero = r.Start()
-	resp, err := http.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return zero, fmt.Errorf("getRed: can't make a request for %q, %w", url, err)
+	}
+	// ask for the wire format, which older servers ignore
+	req.Header.Set("Accept", r.WireContentType+", text/plain;q=0.5")
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		// This common case needs work, specifically an 'error.Is()' expression
 		if strings.Contains(err.Error(), "connection refused") {
@@ -169,33 +223,25 @@ func getRed(url string, verbose bool) (*r.Red, error) {
 			resp.StatusCode, resp.Body)
 	}
 
-	red, err = redFromReader(resp.Body)
+	sample, err := redFromReader(resp.Body)
 	if err != nil {
 		return zero, err
 	}
-	return red, nil
+	return sample, nil
 }
 
-func redFromReader(reader io.Reader) (*r.Red, error) {
-	var line string
-	var duration float64
-	var red, zero r.Red
-
-	x, err := ioutil.ReadAll(reader)
-	// FIXME: ReadAll is really in io in go 1.16, not ioutil, but lints reject that.
-	// Waiting for staticcheck and errcheck to be fixed
-	if err != nil {
-		return &zero, fmt.Errorf("io.Readall failed, reported %#v", err)
-	}
+// redFromReader reads a sample in the wire format, or in the comma format
+// older servers send
+func redFromReader(reader io.Reader) (r.Sample, error) {
+	var sample r.Sample
+	zero := r.Sample{Red: &r.Red{}}
 
-	line = string(x)
-	n, err := fmt.Sscanf(line, "%d, %d, %g", &red.Requests, &red.Errors, &duration)
+	x, err := io.ReadAll(reader)
 	if err != nil {
-		return &zero, fmt.Errorf("sscanf failed to scan a Red from %q, reported %#v", line, err)
+		return zero, fmt.Errorf("io.Readall failed, reported %#v", err)
 	}
-	if n < 3 {
-		return &zero, fmt.Errorf("sscanf failed, read %d fields from %q", n, line)
+	if err = sample.UnmarshalText(x); err != nil {
+		return zero, fmt.Errorf("failed to read a Red from %q, reported %w", x, err)
 	}
-	red.Duration = time.Duration(duration) * time.Second
-	return &red, nil
+	return sample, nil
 }
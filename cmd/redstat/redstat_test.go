@@ -4,12 +4,12 @@ package main
 
 import (
 	"fmt"
+	red "github.com/davecb/RED/pkg/red"
 	"github.com/jarcoal/httpmock"
 	. "github.com/smartystreets/goconvey/convey"
-	"gitlab.indexexchange.com/exchange-node/machine-learning/model-uploader/internal/red"
-	"math/rand"
 	"net/http"
 	"testing"
+	"time"
 )
 
 var delay, count int
@@ -91,11 +91,13 @@ func TestMocked(t *testing.T) {
 		// Set up a mock http server
 		httpmock.Activate()
 		defer httpmock.DeactivateAndReset()
+		requests := 12
 		httpmock.RegisterResponder("GET",
 			url, func(req *http.Request) (*http.Response, error) {
-				// This will produce 18, then 87...
+				// This will produce 18, then 24...
+				requests += 6
 				return httpmock.NewStringResponse(250,
-					fmt.Sprintf("%d, 0, 5280.0", rand.Intn(100))), nil
+					fmt.Sprintf("%d, 0, 5280.0", requests)), nil
 			})
 
 		delay = 1
@@ -108,3 +110,56 @@ func TestMocked(t *testing.T) {
 	})
 
 }
+
+// TestDelta confirms restarts are detected, and never reported as negative counts
+func TestDelta(t *testing.T) {
+	start := time.Now()
+	sample := func(instance string, epoch time.Time, requests, errors int64) red.Sample {
+		return red.Sample{Version: 1, Instance: instance, Epoch: epoch,
+			Red: &red.Red{Requests: requests, Errors: errors, StartTime: epoch}}
+	}
+
+	Convey("Given two samples from the same server, the difference is reported", t, func() {
+		difference, restarted := delta(sample("web1", start, 10, 2), sample("web1", start, 15, 3))
+		So(restarted, ShouldBeFalse)
+		So(difference.Requests, ShouldEqual, 5)
+		So(difference.Errors, ShouldEqual, 1)
+	})
+
+	Convey("Given counts that went down, the new counts are reported as a restart", t, func() {
+		difference, restarted := delta(red.Sample{Red: &red.Red{Requests: 100, Errors: 4}},
+			red.Sample{Red: &red.Red{Requests: 7, Errors: 0}})
+		So(restarted, ShouldBeTrue)
+		So(difference.Requests, ShouldEqual, 7)
+		So(difference.Errors, ShouldEqual, 0)
+	})
+
+	Convey("Given a new epoch with higher counts, the new counts are reported as a restart", t, func() {
+		difference, restarted := delta(sample("web1", start, 10, 2), sample("web1", start.Add(time.Minute), 50, 1))
+		So(restarted, ShouldBeTrue)
+		So(difference.Requests, ShouldEqual, 50)
+		So(difference.Errors, ShouldEqual, 1)
+	})
+
+	Convey("Given a server that crashed after its last checkpoint, and restored its start time", t, func() {
+		first, second := sample("web1", start, 100, 4), sample("web1", start.Add(time.Minute), 90, 3)
+		second.Red.StartTime, second.Restored = first.Red.StartTime, start.Add(-time.Minute)
+		difference, restarted := delta(first, second)
+		Convey("The counts lost since the checkpoint are reported as zero, not its totals", func() {
+			So(restarted, ShouldBeTrue)
+			So(difference.Requests, ShouldEqual, 0)
+			So(difference.Errors, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a server that restored its counts but started a new duration", t, func() {
+		first, second := sample("web1", start, 100, 4), sample("web1", start.Add(time.Minute), 105, 4)
+		second.Restored = start.Add(time.Minute)
+		difference, restarted := delta(first, second)
+		Convey("The difference is reported, not its totals", func() {
+			So(restarted, ShouldBeTrue)
+			So(difference.Requests, ShouldEqual, 5)
+			So(difference.Errors, ShouldEqual, 0)
+		})
+	})
+}
@@ -1,10 +1,16 @@
 module github.com/davecb/RED
 
-go 1.17
+go 1.22
+
+require (
+	github.com/jarcoal/httpmock v1.0.8
+	github.com/smartystreets/goconvey v1.7.2
+	go.uber.org/zap v1.27.0
+)
 
 require (
 	github.com/gopherjs/gopherjs v0.0.0-20181017120253-0766667cb4d1 // indirect
 	github.com/jtolds/gls v4.20.0+incompatible // indirect
 	github.com/smartystreets/assertions v1.2.0 // indirect
-	github.com/smartystreets/goconvey v1.7.2 // indirect
+	go.uber.org/multierr v1.10.0 // indirect
 )
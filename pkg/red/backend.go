@@ -0,0 +1,122 @@
+package red
+
+// backend is how a Red serializes its updates. The channel worker is
+// the default; the others are there to compare it against, and for
+// callers whose Adds are so frequent that even the worker contends.
+
+import (
+	"math/bits"
+	"math/rand"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Backend is an enum of the ways a Red can serialize updates
+type Backend int
+
+const (
+	// Channel sends every operation to a worker goroutine, the default
+	Channel Backend = iota
+	// Mutex applies every operation under a lock, for comparison
+	Mutex
+	// Sharded adds to per-CPU atomic counters, which are summed on every
+	// other operation. Add doesn't wait for anything, nor update its handle.
+	Sharded
+)
+
+func (b Backend) String() string {
+	switch b {
+	case Channel:
+		return "channel"
+	case Mutex:
+		return "mutex"
+	case Sharded:
+		return "sharded"
+	default:
+		return "unknown-backend"
+	}
+}
+
+// Timing is an enum of what a Red's Duration measures
+type Timing int
+
+const (
+	// WallClock is the time since Start(), the default
+	WallClock Timing = iota
+	// BusyTime is the sum of the times from Begin() to End(), so
+	// Duration/Requests is the mean service time
+	BusyTime
+	// CPUTime is the user plus system CPU time the process has used
+	// since Start(), so Duration/Requests is the CPU cost per request
+	CPUTime
+)
+
+func (t Timing) String() string {
+	switch t {
+	case WallClock:
+		return "wall-clock"
+	case BusyTime:
+		return "busy-time"
+	case CPUTime:
+		return "cpu-time"
+	default:
+		return "unknown-timing"
+	}
+}
+
+// Options are the choices made when a Red is created. The zero
+// value gives the same Red that New() does.
+type Options struct {
+	Backend Backend // how updates are serialized
+	Timing  Timing  // what Duration measures
+	Elapsed bool    // also report wall-clock time in Elapsed, if Timing isn't WallClock
+
+	Checkpoint Checkpoint // where to save the counts so they survive a restart, if anywhere
+}
+
+// cacheLine is big enough to keep shards from sharing a cache line
+const cacheLine = 128
+
+// shard is one CPU's share of the counts
+type shard struct {
+	deltas
+	_ [cacheLine - unsafe.Sizeof(deltas{})]byte
+}
+
+// shards are the counters of a Sharded backend, one or more per CPU
+type shards []shard
+
+// newShards makes a power of two shards, at least one per CPU
+func newShards() shards {
+	n := runtime.GOMAXPROCS(0)
+	return make(shards, 1<<bits.Len(uint(n-1)))
+}
+
+// add picks a shard at random, which spreads goroutines across them
+// without knowing which CPU they're on. Callers have already checked f.
+func (s shards) add(f Fields, val int64) {
+	s[rand.Uint32()&uint32(len(s)-1)].add(f, val)
+}
+
+// do has inst apply m, the way its backend serializes operations.
+// Once inst is closed, it returns ErrClosed instead.
+func (inst *instance) do(m msg) error {
+	if inst.backend == Channel {
+		atomic.AddInt64(&inst.senders, 1)
+		if inst.isClosed() {
+			atomic.AddInt64(&inst.senders, -1)
+			return ErrClosed
+		}
+		inst.toWorker <- m
+		atomic.AddInt64(&inst.senders, -1)
+		return nil
+	}
+	inst.lock.Lock()
+	defer inst.lock.Unlock()
+	if inst.isClosed() {
+		return ErrClosed
+	}
+	inst.apply(m)
+	return nil
+}
@@ -0,0 +1,94 @@
+package red
+
+// backend_test is GoConvey tests and benchmarks of the different backends
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkBackends compares Add on each backend, with increasing numbers
+// of goroutines sharing one handle. Unlike BenchmarkAdd, it doesn't use
+// Nanosleep, so it measures the backends themselves.
+func BenchmarkBackends(b *testing.B) {
+	Nanosleep = false
+	for _, backend := range []Backend{Channel, Mutex, Sharded} {
+		for _, goroutines := range []int{1, 8, 64, 1024} {
+			name := fmt.Sprintf("%s-%d", backend, goroutines)
+			b.Run(name, func(b *testing.B) {
+				var r = NewWithOptions("bench-"+name, Options{Backend: backend}).Start()
+				var wg sync.WaitGroup
+
+				wg.Add(goroutines)
+				b.ResetTimer()
+				for g := 0; g < goroutines; g++ {
+					go func(g int) {
+						defer wg.Done()
+						// split b.N adds among the goroutines
+						for i := g; i < b.N; i += goroutines {
+							r.Add(REQUESTS, 1)
+						}
+					}(g)
+				}
+				wg.Wait()
+				b.StopTimer()
+				if now := r.Now(); now.Requests != int64(b.N) {
+					b.Fatalf("%s counted %d adds, expected %d", name, now.Requests, b.N)
+				}
+			})
+		}
+	}
+}
+
+// TestBackends confirms every backend gets the same answers
+func TestBackends(t *testing.T) {
+	for _, backend := range []Backend{Channel, Mutex, Sharded} {
+		var r = NewWithOptions("backend-"+backend.String(), Options{Backend: backend}).Start()
+
+		Convey(fmt.Sprintf("Given a red with the %s backend", backend), t, func() {
+
+			Convey("Concurrent adds are all counted", func() {
+				var wg sync.WaitGroup
+				wg.Add(16)
+				for i := 0; i < 16; i++ {
+					go func() {
+						defer wg.Done()
+						for j := 0; j < 100; j++ {
+							_ = r.Add(REQUESTS, 1)
+							_ = r.Add(ERRORS, 2)
+						}
+					}()
+				}
+				wg.Wait()
+				now := r.Now()
+				So(now.Requests, ShouldEqual, 1600)
+				So(now.Errors, ShouldEqual, 3200)
+				So(now.Duration, ShouldBeGreaterThan, 0)
+			})
+
+			Convey("Set and GetAll see the adds made before them", func() {
+				_ = r.Add(REQUESTS, 1)
+				So(r.Set(ERRORS, 5), ShouldBeNil)
+				So(r.GetAll(), ShouldBeNil)
+				So(r.Requests, ShouldEqual, 1601)
+				So(r.Errors, ShouldEqual, 5)
+			})
+
+			Convey("Batched handles work with it too", func() {
+				b, err := r.Batched(10, time.Hour)
+				So(err, ShouldBeNil)
+				for i := 0; i < 25; i++ {
+					_ = b.Add(REQUESTS, 1)
+				}
+				So(r.Now().Requests, ShouldEqual, 1626)
+			})
+
+			Convey("Start resets it", func() {
+				So(r.Start().Now().Requests, ShouldEqual, 0)
+			})
+		})
+	}
+}
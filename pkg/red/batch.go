@@ -0,0 +1,123 @@
+package red
+
+// batch is the fire-and-forget mode of Add: a batched handle
+// collects its deltas locally, with atomic adds instead of a
+// round trip to the worker, and the worker picks them up.
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// batcher holds the adds a batched handle hasn't handed to the worker yet.
+// The handle adds to it and the worker drains it, both atomically.
+type batcher struct {
+	deltas
+	adds int64 // atomic, the number of Add calls, for the size limit
+
+	inst     *instance
+	ser      *series // the series the adds are to
+	size     int64
+	interval time.Duration
+}
+
+// Batched returns a new handle on the same Red as r, whose Add returns
+// without waiting for the worker. Its adds are sent to the worker every
+// size calls or every interval, whichever comes first, or when Flush()
+// is called. Now() and GetAll() still report exact totals.
+// Use one per long-lived caller, not one per request: the worker keeps them.
+func (r *Red) Batched(size int, interval time.Duration) (*Red, error) {
+	if r == nil || r.inst == nil {
+		return nil, fmt.Errorf("r is nil, please call Start() first")
+	}
+	if size < 1 || interval <= 0 {
+		return nil, fmt.Errorf("usage error, Batched(%d, %s) needs a positive size and interval", size, interval)
+	}
+	b := &batcher{
+		inst:     r.inst,
+		ser:      r.target(),
+		size:     int64(size),
+		interval: interval,
+	}
+	a, err := r.ask(msg{operation: batch, batch: b})
+	if err != nil {
+		return nil, err
+	}
+	tmp := a.red
+	tmp.batch = b
+	return &tmp, nil
+}
+
+// Flush has the worker pick up all the batched adds. It's only needed
+// before reading the fields of a Red some other way than Now() or GetAll().
+func (r *Red) Flush() error {
+	if r == nil || r.inst == nil {
+		return fmt.Errorf("r is nil, please call Start() first")
+	}
+	return r.update(r.call(flush, NONE, 0))
+}
+
+// add accumulates a delta, and tells the worker to drain when the
+// batch is full. Callers have already checked f.
+func (b *batcher) add(f Fields, val int64) error {
+	if b.inst.isClosed() {
+		return ErrClosed
+	}
+	b.deltas.add(f, val)
+	return b.added()
+}
+
+// added counts an Add, and tells the worker to drain when the batch is full
+func (b *batcher) added() error {
+	if atomic.AddInt64(&b.adds, 1)%b.size == 0 {
+		// The deltas stay here until the worker takes them, so
+		// nothing is ever in flight where Now() can't see it.
+		return b.inst.do(msg{operation: flush})
+	}
+	return nil
+}
+
+// deltas are counts not yet seen by the worker. They're
+// added to atomically, and drained into main the same way.
+type deltas struct {
+	requests int64 // atomic
+	errors   int64 // atomic
+	duration int64 // atomic, busy time from Timer.End
+}
+
+// add adds to one of d's counts. Callers have already checked f.
+func (d *deltas) add(f Fields, val int64) {
+	switch f {
+	case REQUESTS:
+		atomic.AddInt64(&d.requests, val)
+	case ERRORS:
+		atomic.AddInt64(&d.errors, val)
+	case DURATION:
+		atomic.AddInt64(&d.duration, val)
+	}
+}
+
+// drainInto moves d's counts into main, keeping the duration only if
+// main's Duration is busy time.
+func (d *deltas) drainInto(main *Red, busy bool) {
+	main.Requests += atomic.SwapInt64(&d.requests, 0)
+	main.Errors += atomic.SwapInt64(&d.errors, 0)
+	if duration := atomic.SwapInt64(&d.duration, 0); busy {
+		main.Duration += time.Duration(duration)
+	}
+}
+
+// drain moves every batched and sharded delta into its series. Only
+// the worker, or whoever holds the backend's lock, calls it.
+func (inst *instance) drain() {
+	busy := inst.timing == BusyTime
+	for _, s := range inst.series {
+		for i := range s.shards {
+			s.shards[i].drainInto(&s.main, busy)
+		}
+	}
+	for _, b := range inst.batchers {
+		b.drainInto(&b.ser.main, busy)
+	}
+}
@@ -0,0 +1,81 @@
+package red
+
+// batch_test is GoConvey tests of batched, fire-and-forget adds
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkBatchedAdd is a timing test of Add on a batched handle
+func BenchmarkBatchedAdd(b *testing.B) {
+	var r, _ = New("bench-batched").Start().Batched(100, time.Second)
+	var wg sync.WaitGroup
+
+	wg.Add(b.N)
+	Nanosleep = true
+	for i := 0; i < b.N; i++ {
+		go func() {
+			r.Add(REQUESTS, 1)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	b.Logf("red reported %q\n", r.Now().String())
+}
+
+// TestBatchedAdd confirms batched adds are all counted, exactly once
+func TestBatchedAdd(t *testing.T) {
+	var r = New("batched").Start()
+
+	Convey("Given a batched handle", t, func() {
+		b, err := r.Batched(10, time.Hour)
+		So(err, ShouldBeNil)
+
+		Convey("Adds smaller than a batch still show up in Now()", func() {
+			_ = b.Add(REQUESTS, 1)
+			_ = b.Add(ERRORS, 1)
+			now := r.Now()
+			So(now.Requests, ShouldEqual, 1)
+			So(now.Errors, ShouldEqual, 1)
+		})
+
+		Convey("Concurrent adds are counted exactly once", func() {
+			var wg sync.WaitGroup
+			wg.Add(20)
+			for i := 0; i < 20; i++ {
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 55; j++ {
+						_ = b.Add(REQUESTS, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			So(b.Flush(), ShouldBeNil)
+			So(b.Requests, ShouldEqual, 1+20*55)
+			So(r.Now().Requests, ShouldEqual, 1+20*55)
+		})
+	})
+
+	Convey("Given a batched handle with a short interval", t, func() {
+		b, _ := New("batched-interval").Start().Batched(1000, 10*time.Millisecond)
+		_ = b.Add(REQUESTS, 7)
+		time.Sleep(50 * time.Millisecond)
+
+		Convey("The worker picks up its adds without being asked", func() {
+			So(atomic.LoadInt64(&b.batch.requests), ShouldEqual, 0)
+			So(b.Now().Requests, ShouldEqual, 7)
+		})
+	})
+
+	Convey("Given bad batch sizes, Batched returns an error", t, func() {
+		_, err := r.Batched(0, time.Second)
+		So(err, ShouldNotBeNil)
+		_, err = (*Red)(nil).Batched(1, time.Second)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,218 @@
+package red
+
+// checkpoint saves a Red's counts to a file, and restores them when
+// it's created again, so long-term totals survive a restart
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checkpoint is where and how often to save a Red. The zero value
+// doesn't save anything.
+type Checkpoint struct {
+	Path     string        // the file to save to and restore from, or "" for none
+	Interval time.Duration // how often to save, by default 1 minute, and always at Close()
+	Start    StartPolicy   // what a restored Red does with its start time
+}
+
+// StartPolicy is an enum of what a Red restored from a checkpoint does
+// with its start time
+type StartPolicy int
+
+const (
+	// KeepStart carries the start time and Duration over, so they and
+	// the counts are totals since the Red was first started. With
+	// WallClock timing, Duration includes the time it was down.
+	KeepStart StartPolicy = iota
+	// NewStart carries the counts over, but starts a new Duration now
+	NewStart
+)
+
+func (p StartPolicy) String() string {
+	switch p {
+	case KeepStart:
+		return "keep-start"
+	case NewStart:
+		return "new-start"
+	default:
+		return "unknown-start-policy"
+	}
+}
+
+// checkpointFile is what's saved: every series, in the JSON of MarshalJSON.
+// Latencies from Observe() aren't restored.
+type checkpointFile struct {
+	Name   string    `json:"name"`
+	Timing string    `json:"timing"`
+	Saved  time.Time `json:"saved"`
+	Series []*Red    `json:"series"`
+}
+
+// saver saves one Red's checkpoints, one at a time
+type saver struct {
+	opts  Checkpoint
+	mu    sync.Mutex    // serializes saves, so an older one can't replace a newer
+	final bool          // set by the save at Close(), after which there are no more
+	stop  chan struct{} // closed at Close(), to stop the periodic saves
+}
+
+// newSaver fills in the defaults
+func newSaver(opts Checkpoint) *saver {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	return &saver{opts: opts, stop: make(chan struct{})}
+}
+
+// periodically saves inst every interval, until it's closed
+func (s *saver) periodically(inst *instance) {
+	tick := time.NewTicker(s.opts.Interval)
+	defer tick.Stop()
+
+	r := &Red{inst: inst}
+	for {
+		select {
+		case <-tick.C:
+			reds := r.NowSeries()
+			if reds == nil {
+				// it's closing, and the final save will do
+				return
+			}
+			if err := s.save(inst, reds, false); err != nil {
+				log.Printf("red %q: checkpoint failed, will retry. Message was %q\n", inst.name, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// saveFinal saves every series as Close() leaves them, and stops the
+// periodic saves. Only the worker, or whoever holds the backend's lock,
+// calls it.
+func (inst *instance) saveFinal() {
+	if inst.saver == nil {
+		return
+	}
+	t := time.Now()
+	var reds []*Red
+	for _, s := range inst.series {
+		tmp := inst.snapshot(s, t)
+		reds = append(reds, &tmp)
+	}
+	sort.Slice(reds, func(i, j int) bool {
+		return reds[i].Labels.key() < reds[j].Labels.key()
+	})
+	if err := inst.saver.save(inst, reds, true); err != nil {
+		log.Printf("red %q: final checkpoint failed, counts since the last one are lost. Message was %q\n", inst.name, err)
+	}
+	close(inst.saver.stop)
+}
+
+// save writes reds to a temporary file in the same directory, syncs it,
+// and renames it over the checkpoint, so a crash leaves either the old
+// checkpoint or the new one, never part of one
+func (s *saver) save(inst *instance, reds []*Red, final bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.final {
+		return nil
+	}
+	s.final = final
+
+	j, err := json.Marshal(checkpointFile{
+		Name:   inst.name,
+		Timing: inst.timing.String(),
+		Saved:  time.Now(),
+		Series: reds,
+	})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.opts.Path)
+	f, err := os.CreateTemp(dir, filepath.Base(s.opts.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(j)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.opts.Path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	// make the rename durable too, where directories can be synced
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// restore sets inst's series from its checkpoint, if there is one. It's
+// called before the worker starts. If the checkpoint can't be read, it
+// logs why and inst starts from zero.
+func (inst *instance) restore(t time.Time) {
+	j, err := os.ReadFile(inst.saver.opts.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var saved checkpointFile
+	if err == nil {
+		err = json.Unmarshal(j, &saved)
+	}
+	if err != nil {
+		log.Printf("red %q: can't restore from %q, starting from zero. Message was %q\n",
+			inst.name, inst.saver.opts.Path, err)
+		return
+	}
+	if saved.Name != inst.name {
+		log.Printf("red %q: restoring from %q, which was saved by %q\n", inst.name, inst.saver.opts.Path, saved.Name)
+	}
+
+	for _, r := range saved.Series {
+		if r == nil {
+			continue
+		}
+		s := inst.root
+		if len(r.Labels) > 0 {
+			s = inst.newSeries(r.Labels, t)
+		}
+		s.main.Requests, s.main.Errors = r.Requests, r.Errors
+		restored := saved.Saved
+		if restored.IsZero() {
+			restored = t
+		}
+		atomic.StoreInt64(&s.restored, restored.UnixNano())
+		if inst.saver.opts.Start == NewStart || r.StartTime.IsZero() {
+			continue
+		}
+		s.main.StartTime = r.StartTime
+		if saved.Timing != inst.timing.String() {
+			// the saved Duration measured something else
+			continue
+		}
+		switch inst.timing {
+		case BusyTime:
+			s.main.Duration = r.Duration
+		case CPUTime:
+			s.cpuAt -= r.Duration
+		}
+	}
+}
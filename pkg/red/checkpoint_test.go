@@ -0,0 +1,102 @@
+package red
+
+// checkpoint_test is GoConvey tests of saving and restoring counts
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCheckpoint confirms counts are saved periodically and at Close(), and restored
+func TestCheckpoint(t *testing.T) {
+	Convey("Given a red that checkpoints to a file", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "uploads.json")
+		opts := Options{Timing: BusyTime, Checkpoint: Checkpoint{Path: path, Interval: 10 * time.Millisecond}}
+		r := NewRegistry().NewWithOptions("uploads", opts)
+		_ = r.Add(REQUESTS, 5)
+		_ = r.Add(ERRORS, 1)
+		_ = r.With("endpoint", "/upload").Begin().End(nil)
+
+		Convey("It's saved periodically", func() {
+			var saved checkpointFile
+			So(waitFor(func() bool {
+				j, err := os.ReadFile(path)
+				return err == nil && json.Unmarshal(j, &saved) == nil && len(saved.Series) == 2
+			}), ShouldBeTrue)
+			So(saved.Name, ShouldEqual, "uploads")
+			So(saved.Series[0].Requests, ShouldEqual, 5)
+			So(saved.Series[1].Labels.Get("endpoint"), ShouldEqual, "/upload")
+			_, _ = r.Close(context.Background())
+		})
+
+		Convey("When it's closed and created again, the counts carry over", func() {
+			_ = r.Add(REQUESTS, 1)
+			first, err := r.Close(context.Background())
+			So(err, ShouldBeNil)
+			files, _ := os.ReadDir(dir)
+			So(len(files), ShouldEqual, 1) // no temporary files left
+
+			again := NewRegistry().NewWithOptions("uploads", opts)
+			defer again.Close(context.Background())
+			now := again.Now()
+			So(now.Requests, ShouldEqual, 6)
+			So(now.Errors, ShouldEqual, 1)
+			So(now.StartTime.Equal(first.StartTime), ShouldBeTrue)
+			So(again.Sample().Restored.IsZero(), ShouldBeFalse)
+			So(r.Sample().Restored.IsZero(), ShouldBeTrue)
+			upload := again.NowSeries("endpoint", "/upload")
+			So(len(upload), ShouldEqual, 1)
+			So(upload[0].Requests, ShouldEqual, 1)
+			So(upload[0].Duration, ShouldBeGreaterThan, 0)
+
+			Convey("Until it's restarted, when the counts are its own", func() {
+				_, err := again.Swap()
+				So(err, ShouldBeNil)
+				So(again.Sample().Restored.IsZero(), ShouldBeTrue)
+				upload := again.With("endpoint", "/upload")
+				So(upload.Sample().Restored.IsZero(), ShouldBeFalse)
+				again.Start()
+				So(upload.Sample().Restored.IsZero(), ShouldBeTrue)
+			})
+		})
+
+		Convey("With NewStart, the counts carry over but the start time and Duration don't", func() {
+			_, _ = r.Close(context.Background())
+			opts.Checkpoint.Start = NewStart
+			before := time.Now()
+			again := NewRegistry().NewWithOptions("uploads", opts)
+			defer again.Close(context.Background())
+			now := again.Now()
+			So(now.Requests, ShouldEqual, 5)
+			So(now.StartTime, ShouldHappenOnOrAfter, before)
+			So(again.NowSeries("endpoint", "/upload")[0].Duration, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a checkpoint that can't be read", t, func() {
+		path := filepath.Join(t.TempDir(), "broken.json")
+		So(os.WriteFile(path, []byte(`{"series":[{"version":1,"requ`), 0o644), ShouldBeNil)
+
+		Convey("The red starts from zero", func() {
+			r := NewRegistry().NewWithOptions("uploads", Options{Checkpoint: Checkpoint{Path: path}})
+			defer r.Close(context.Background())
+			So(r.Now().Requests, ShouldEqual, 0)
+		})
+	})
+}
+
+// waitFor polls cond for up to a second
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
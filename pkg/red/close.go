@@ -0,0 +1,92 @@
+package red
+
+// close stops a Red's worker, for tests and for servers that are
+// shutting down and want a final report.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+)
+
+// ErrClosed is returned by operations on a Red after Close() is called
+var ErrClosed = errors.New("red is closed, please call New() for a new one")
+
+// Close stops the Red that r is a handle on from accepting operations,
+// finishes the ones already queued, and returns the final values of r's
+// series. Its worker then exits, and it's removed from its registry, so
+// operations on it return ErrClosed. If ctx is done first, Close returns
+// ctx.Err(), but the Red still closes. Adds made while Close is running
+// on a Sharded backend or a batched handle may not be counted.
+func (r *Red) Close(ctx context.Context) (*Red, error) {
+	if r == nil || r.inst == nil {
+		return nil, fmt.Errorf("r is nil, please call Start() first")
+	}
+	if !atomic.CompareAndSwapInt32(&r.inst.closed, 0, 1) {
+		return nil, ErrClosed
+	}
+	r.inst.reg.remove(r.inst)
+	// not from the pool, as it's abandoned if ctx is done
+	ch := make(chan answer, 1)
+	// closing can wait for a full queue, a lock, or the final checkpoint,
+	// so it carries on without us if ctx is done first
+	go r.inst.close(msg{operation: closing, reply: ch, series: r.ser})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case a := <-ch:
+		return &a.red, a.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the default Red, the one the package-level Start() uses
+func Close(ctx context.Context) (*Red, error) {
+	r := Default.Get(DefaultName)
+	if r == nil {
+		return nil, fmt.Errorf("the default red isn't running, so can't be closed")
+	}
+	return r.Close(ctx)
+}
+
+// isClosed reports whether Close() has started
+func (inst *instance) isClosed() bool {
+	return atomic.LoadInt32(&inst.closed) != 0
+}
+
+// close sends the closing message m, bypassing the check in do(),
+// and, if the backend isn't Channel, stops the worker, which only ticks
+func (inst *instance) close(m msg) {
+	if inst.backend == Channel {
+		inst.toWorker <- m
+		return
+	}
+	inst.lock.Lock()
+	defer inst.lock.Unlock()
+	inst.apply(m)
+	close(inst.toWorker)
+}
+
+// quiesce applies the operations that were sent to the Channel backend
+// before it was closed. Only the worker calls it.
+func (inst *instance) quiesce() {
+	for {
+		// Once no sender is between checking closed and sending,
+		// everything that will ever be sent is in the channel.
+		idle := atomic.LoadInt64(&inst.senders) == 0
+		select {
+		case m := <-inst.toWorker:
+			inst.apply(m)
+		default:
+			if idle {
+				return
+			}
+			runtime.Gosched()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package red
+
+// close_test is GoConvey tests of closing a Red
+
+import (
+	"context"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestClose confirms Close drains what was queued, and later operations fail
+func TestClose(t *testing.T) {
+	for _, backend := range []Backend{Channel, Mutex, Sharded} {
+		Convey(fmt.Sprintf("Given a %s red that's busy when it's closed", backend), t, func() {
+			reg := NewRegistry()
+			r := reg.NewWithOptions("closing", Options{Backend: backend})
+			b, _ := r.Batched(1000, time.Hour)
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				_ = b.Add(REQUESTS, 1)
+				_ = r.Observe(time.Millisecond)
+			}
+			wg.Add(4)
+			for i := 0; i < 4; i++ {
+				go func() {
+					defer wg.Done()
+					for r.Add(ERRORS, 1) == nil {
+					}
+				}()
+			}
+			time.Sleep(10 * time.Millisecond)
+			final, err := r.Close(context.Background())
+			wg.Wait()
+
+			Convey("Close returns the final values, including everything queued", func() {
+				So(err, ShouldBeNil)
+				So(final.Requests, ShouldEqual, 100)
+				So(final.Latency.Count, ShouldEqual, 100)
+				So(final.Errors, ShouldBeGreaterThan, 0)
+			})
+
+			Convey("Operations afterwards return errors instead of blocking", func() {
+				So(r.Add(REQUESTS, 1), ShouldEqual, ErrClosed)
+				So(b.Add(REQUESTS, 1), ShouldEqual, ErrClosed)
+				So(r.GetAll(), ShouldEqual, ErrClosed)
+				So(r.Set(ERRORS, 1), ShouldEqual, ErrClosed)
+				So(r.Observe(time.Second), ShouldEqual, ErrClosed)
+				So(r.Begin().End(nil), ShouldEqual, ErrClosed)
+				So(r.With("endpoint", "/upload").Add(REQUESTS, 1), ShouldEqual, ErrClosed)
+				_, err := r.Swap()
+				So(err, ShouldEqual, ErrClosed)
+				_, err = r.Close(context.Background())
+				So(err, ShouldEqual, ErrClosed)
+			})
+
+			Convey("Now still returns a Red, and the name can be reused", func() {
+				So(r.Now(), ShouldNotBeNil)
+				So(reg.Get("closing"), ShouldBeNil)
+				So(reg.New("closing").Add(REQUESTS, 1), ShouldBeNil)
+			})
+		})
+	}
+
+	Convey("Given a context that's already done, Close returns its error", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r := NewRegistry().New("cancelled")
+		for i := 0; i < 100; i++ {
+			_ = r.Observe(time.Millisecond)
+		}
+		_, err := r.Close(ctx)
+		So(err, ShouldEqual, context.Canceled)
+		So(r.Add(REQUESTS, 1), ShouldEqual, ErrClosed)
+	})
+}
@@ -0,0 +1,20 @@
+package red
+
+// cpu gets the process' CPU time for the CPUTime timing, as shown
+// in "Seconds or CPU-Seconds?" in Red.md
+
+import (
+	"log"
+	"time"
+)
+
+// cpuTime returns the user plus system time of the process so far. If
+// the kernel can't tell us, it logs why and returns zero.
+func (inst *instance) cpuTime() time.Duration {
+	t, err := processCPUTime()
+	if err != nil {
+		log.Printf("red %q: can't get the CPU time, reporting zero. Message was %q\n", inst.name, err)
+		return 0
+	}
+	return t
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package red
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// processCPUTime needs getrusage, which this platform doesn't have
+func processCPUTime() (time.Duration, error) {
+	return 0, fmt.Errorf("getrusage isn't supported on %s", runtime.GOOS)
+}
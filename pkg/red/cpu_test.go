@@ -0,0 +1,36 @@
+//go:build unix
+
+package red
+
+// cpu_test is GoConvey tests of the CPUTime timing
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// TestCPUTime confirms Duration is CPU time, with wall-clock time alongside
+func TestCPUTime(t *testing.T) {
+	Convey("Given a cpu-time red that also reports elapsed time", t, func() {
+		r := NewWithOptions("cpu", Options{Timing: CPUTime, Elapsed: true}).Start()
+
+		Convey("Sleeping uses no CPU, but the time still elapses", func() {
+			time.Sleep(50 * time.Millisecond)
+			now := r.Now()
+			So(now.Duration, ShouldBeLessThan, 50*time.Millisecond)
+			So(now.Elapsed, ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+		})
+
+		Convey("Spinning uses CPU", func() {
+			for begun := time.Now(); time.Since(begun) < 50*time.Millisecond; {
+			}
+			So(r.Now().Duration, ShouldBeGreaterThan, 10*time.Millisecond)
+		})
+	})
+
+	Convey("Given a wall-clock red, Elapsed isn't reported", t, func() {
+		r := NewWithOptions("cpu-wall", Options{Elapsed: true}).Start()
+		So(r.Now().Elapsed, ShouldEqual, 0)
+	})
+}
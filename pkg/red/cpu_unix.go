@@ -0,0 +1,24 @@
+//go:build unix
+
+package red
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime asks the kernel for the user and system time of the process
+func processCPUTime() (time.Duration, error) {
+	var ru syscall.Rusage
+
+	err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	if err != nil {
+		return 0, err
+	}
+	return duration(ru.Utime) + duration(ru.Stime), nil
+}
+
+// duration converts a syscall.Timeval to a time.Duration
+func duration(t syscall.Timeval) time.Duration {
+	return time.Duration(t.Sec)*time.Second + time.Duration(t.Usec)*time.Microsecond
+}
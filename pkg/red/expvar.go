@@ -0,0 +1,71 @@
+package red
+
+// expvar publishes Reds in /debug/vars, for tools that already read it
+
+import (
+	"encoding/json"
+	"expvar"
+)
+
+// Publish makes r, and all its labelled series, an expvar called name.
+// Each read of it calls NowSeries(), so its Durations are current, and
+// gives the Red's JSON with the series added, as in
+//
+//	"uploads": {"version":1,"requests":3,"errors":1,"duration_seconds":3.000667,...,
+//	            "series":{"{endpoint=\"/upload\"}":{"version":1,"requests":2,...}}}
+//
+// Like expvar.Publish, it panics if name is already published.
+func Publish(name string, r *Red) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return redVar(r)
+	}))
+}
+
+// Publish makes every Red in reg an expvar called name, as an object
+// with a member for each, by name. Reds created after it's published
+// are included too.
+func (reg *Registry) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		reds := make(map[string]json.RawMessage)
+		for _, n := range reg.Names() {
+			if r := reg.Get(n); r != nil {
+				reds[n] = redVar(r)
+			}
+		}
+		return reds
+	}))
+}
+
+// redVar is the JSON of r's unlabelled series, with the others in "series"
+func redVar(r *Red) json.RawMessage {
+	var root []byte
+	series := make(map[string]json.RawMessage)
+	for _, s := range r.NowSeries() {
+		j, err := s.MarshalJSON()
+		if err != nil {
+			continue
+		}
+		if len(s.Labels) == 0 {
+			root = j
+		} else {
+			series[s.Labels.String()] = j
+		}
+	}
+	if root == nil {
+		// it's closed, so report the last values it was given
+		root, _ = r.Now().MarshalJSON()
+	}
+	if len(series) == 0 {
+		return root
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(root, &fields); err != nil {
+		return root
+	}
+	fields["series"], _ = json.Marshal(series)
+	j, err := json.Marshal(fields)
+	if err != nil {
+		return root
+	}
+	return j
+}
@@ -0,0 +1,57 @@
+package red
+
+// expvar_test is GoConvey tests of publishing Reds as expvars
+
+import (
+	"encoding/json"
+	"expvar"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPublish confirms each read is a fresh snapshot, with the series
+func TestPublish(t *testing.T) {
+	Convey("Given a published red with a labelled series", t, func() {
+		reg := NewRegistry()
+		r := reg.New("uploads")
+		_ = r.Add(REQUESTS, 3)
+		_ = r.With("endpoint", "/upload").Add(ERRORS, 1)
+		name := "red-test-" + time.Now().Format(time.RFC3339Nano)
+		Publish(name, r)
+
+		Convey("Reading it gives the counts, series and a current duration", func() {
+			var v struct {
+				Requests int64
+				Duration float64 `json:"duration_seconds"`
+				Series   map[string]struct{ Errors int64 }
+			}
+			So(json.Unmarshal([]byte(expvar.Get(name).String()), &v), ShouldBeNil)
+			So(v.Requests, ShouldEqual, 3)
+			So(v.Duration, ShouldBeGreaterThan, 0)
+			So(v.Series[`{endpoint="/upload"}`].Errors, ShouldEqual, 1)
+
+			first := v.Duration
+			time.Sleep(time.Millisecond)
+			So(json.Unmarshal([]byte(expvar.Get(name).String()), &v), ShouldBeNil)
+			So(v.Duration, ShouldBeGreaterThan, first)
+		})
+
+		Convey("Publishing the registry includes every red in it, even new ones", func() {
+			reg.Publish(name + "-all")
+			_ = reg.New("downloads").Add(REQUESTS, 7)
+			var v map[string]struct{ Requests int64 }
+			So(json.Unmarshal([]byte(expvar.Get(name+"-all").String()), &v), ShouldBeNil)
+			So(v["uploads"].Requests, ShouldEqual, 3)
+			So(v["downloads"].Requests, ShouldEqual, 7)
+
+			Convey("And it's served in /debug/vars", func() {
+				rec := httptest.NewRecorder()
+				expvar.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/vars", nil))
+				So(strings.Contains(rec.Body.String(), `"`+name+`-all": {"downloads":{"version":1,"requests":7,`), ShouldBeTrue)
+			})
+		})
+	})
+}
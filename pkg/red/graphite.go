@@ -0,0 +1,218 @@
+package red
+
+// graphite writes Reds to Carbon, for sites whose monitoring is Graphite.
+// Like Influx, it's given totals, and the rates are computed by Graphite's
+// derivative() or Grafana.
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+// GraphiteOptions are the choices for a Graphite exporter. The zero
+// value writes plaintext to a local Carbon every minute.
+type GraphiteOptions struct {
+	Address    string        // host:port of Carbon, by default 127.0.0.1:2003, or :2004 for pickle
+	Prefix     string        // put in front of every path, as in "myapp."
+	Interval   time.Duration // how often Run writes, by default 1 minute
+	Pickle     bool          // use the batched pickle protocol instead of plaintext
+	BatchSize  int           // the most metrics in one pickle, by default 500
+	QueueSize  int           // the most metrics kept while Carbon is unreachable, by default 10,000
+	Backoff    time.Duration // the first wait before reconnecting, doubled each time, by default 1 second
+	MaxBackoff time.Duration // the longest wait before reconnecting, by default 1 minute
+}
+
+// GraphiteExporter writes the totals of every series of a Red, as
+// paths like myapp.uploads.endpoint._upload.requests. While Carbon is
+// unreachable it keeps the newest QueueSize metrics, and sends them
+// once it reconnects.
+type GraphiteExporter struct {
+	r       *Red
+	opts    GraphiteOptions
+	dial    func() (net.Conn, error)
+	conn    net.Conn
+	queue   []graphiteMetric
+	wait    time.Duration // the current backoff
+	retryAt time.Time     // when to try reconnecting
+}
+
+// graphiteMetric is one path value timestamp triple
+type graphiteMetric struct {
+	path  string
+	value float64
+	at    int64
+}
+
+// NewGraphiteExporter creates an exporter for r. It doesn't connect
+// until the first Push.
+func NewGraphiteExporter(r *Red, opts GraphiteOptions) (*GraphiteExporter, error) {
+	if r == nil || r.inst == nil {
+		return nil, fmt.Errorf("r is nil, please call Start() first")
+	}
+	if opts.Address == "" {
+		opts.Address = "127.0.0.1:2003"
+		if opts.Pickle {
+			opts.Address = "127.0.0.1:2004"
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Minute
+	}
+	opts.MaxBackoff = max(opts.MaxBackoff, opts.Backoff)
+	return &GraphiteExporter{
+		r:    r,
+		opts: opts,
+		dial: func() (net.Conn, error) {
+			return net.DialTimeout("tcp", opts.Address, 10*time.Second)
+		},
+	}, nil
+}
+
+// Run writes every interval until ctx is done, then writes once more
+// and closes the connection. Failed writes are logged, and their
+// metrics are queued for the next interval's.
+func (e *GraphiteExporter) Run(ctx context.Context) error {
+	tick := time.NewTicker(e.opts.Interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := e.Push(); err != nil {
+				log.Printf("graphite exporter for %q: push failed, will retry. Message was %q\n", e.r.Name(), err)
+			}
+		case <-ctx.Done():
+			err := e.Push()
+			if e.conn != nil {
+				if cerr := e.conn.Close(); err == nil {
+					err = cerr
+				}
+				e.conn = nil
+			}
+			return err
+		}
+	}
+}
+
+// Push queues the current totals and sends everything queued. If
+// Carbon can't be reached, they stay queued until a later Push.
+func (e *GraphiteExporter) Push() error {
+	at := time.Now().Unix()
+	for _, now := range e.r.NowSeries() {
+		path := e.opts.Prefix + dottedPath(now.Name(), now.Labels)
+		e.enqueue(
+			graphiteMetric{path + ".requests", float64(now.Requests), at},
+			graphiteMetric{path + ".errors", float64(now.Errors), at},
+			graphiteMetric{path + ".duration", now.Duration.Seconds(), at})
+	}
+	return e.flush()
+}
+
+// enqueue adds metrics to the queue, dropping the oldest if it's full
+func (e *GraphiteExporter) enqueue(metrics ...graphiteMetric) {
+	e.queue = append(e.queue, metrics...)
+	if over := len(e.queue) - e.opts.QueueSize; over > 0 {
+		log.Printf("graphite exporter for %q: queue is full, dropped the oldest %d metrics\n", e.r.Name(), over)
+		e.queue = append(e.queue[:0], e.queue[over:]...)
+	}
+}
+
+// flush connects if need be, and sends the queue a batch at a time,
+// removing each batch once it's written
+func (e *GraphiteExporter) flush() error {
+	if e.conn == nil {
+		if time.Now().Before(e.retryAt) {
+			return fmt.Errorf("carbon at %q is unreachable, will reconnect after %s",
+				e.opts.Address, e.retryAt.Format(time.RFC3339))
+		}
+		conn, err := e.dial()
+		if err != nil {
+			e.backoff()
+			return fmt.Errorf("can't connect to carbon at %q, %w", e.opts.Address, err)
+		}
+		e.conn, e.wait = conn, 0
+	}
+
+	for len(e.queue) > 0 {
+		n := min(len(e.queue), e.opts.BatchSize)
+		var b []byte
+		if e.opts.Pickle {
+			b = pickle(e.queue[:n])
+		} else {
+			b = plaintext(e.queue[:n])
+		}
+		_ = e.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := e.conn.Write(b); err != nil {
+			_ = e.conn.Close()
+			e.conn = nil
+			e.backoff()
+			return fmt.Errorf("can't write to carbon at %q, %w", e.opts.Address, err)
+		}
+		e.queue = e.queue[n:]
+	}
+	e.queue = nil
+	return nil
+}
+
+// backoff doubles the wait before the next reconnect, up to MaxBackoff
+func (e *GraphiteExporter) backoff() {
+	e.wait = min(max(2*e.wait, e.opts.Backoff), e.opts.MaxBackoff)
+	e.retryAt = time.Now().Add(e.wait)
+}
+
+// plaintext formats metrics as Carbon's path value timestamp lines
+func plaintext(metrics []graphiteMetric) []byte {
+	var b bytes.Buffer
+	for _, m := range metrics {
+		b.WriteString(m.path)
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(m.value, 'f', -1, 64))
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatInt(m.at, 10))
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// pickle formats metrics as Carbon's pickle protocol: a four-byte
+// big-endian length, then a protocol 2 pickle of the Python list
+// [(path, (timestamp, value)), ...]
+func pickle(metrics []graphiteMetric) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0, 0, 0, 0}) // the length, filled in below
+	b.WriteString("\x80\x02")   // PROTO 2
+	b.WriteString("](")         // EMPTY_LIST, MARK
+	for _, m := range metrics {
+		b.WriteByte('X') // BINUNICODE
+		_ = binary.Write(&b, binary.LittleEndian, uint32(len(m.path)))
+		b.WriteString(m.path)
+		b.WriteString("I" + strconv.FormatInt(m.at, 10) + "\n") // INT
+		b.WriteByte('G')                                        // BINFLOAT
+		_ = binary.Write(&b, binary.BigEndian, math.Float64bits(m.value))
+		b.WriteString("\x86\x86") // TUPLE2 twice
+	}
+	b.WriteString("e.") // APPENDS, STOP
+
+	out := b.Bytes()
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	return out
+}
@@ -0,0 +1,134 @@
+package red
+
+// graphite_test is GoConvey tests of the Graphite exporter, against a local TCP listener
+
+import (
+	"bufio"
+	"encoding/binary"
+	. "github.com/smartystreets/goconvey/convey"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// carbon starts a TCP listener, and returns it and a channel of the
+// lines written to it
+func carbon(addr string) (net.Listener, chan string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+	return ln, lines
+}
+
+// receive reads n lines, or fewer if they don't arrive in time
+func receive(lines chan string, n int) []string {
+	var got []string
+	for len(got) < n {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-time.After(time.Second):
+			return got
+		}
+	}
+	return got
+}
+
+// TestGraphiteExporter confirms the exporter writes paths, queues and reconnects
+func TestGraphiteExporter(t *testing.T) {
+	Convey("Given a red and a graphite exporter for it", t, func() {
+		ln, lines := carbon("127.0.0.1:0")
+		defer ln.Close()
+		r := NewRegistry().NewWithOptions("uploads", Options{Timing: BusyTime})
+		_ = r.Add(REQUESTS, 5)
+		_ = r.With("endpoint", "/upload").Add(ERRORS, 2)
+		e, err := NewGraphiteExporter(r, GraphiteOptions{Address: ln.Addr().String(), Prefix: "myapp."})
+		So(err, ShouldBeNil)
+
+		Convey("A push writes a line for every field of every series", func() {
+			So(e.Push(), ShouldBeNil)
+			got := receive(lines, 6)
+			So(len(got), ShouldEqual, 6)
+			at := strings.Fields(got[0])[2]
+			So(got, ShouldResemble, []string{
+				"myapp.uploads.requests 5 " + at,
+				"myapp.uploads.errors 0 " + at,
+				"myapp.uploads.duration 0 " + at,
+				"myapp.uploads.endpoint._upload.requests 0 " + at,
+				"myapp.uploads.endpoint._upload.errors 2 " + at,
+				"myapp.uploads.endpoint._upload.duration 0 " + at,
+			})
+		})
+
+		Convey("While carbon is unreachable, metrics are queued and the oldest dropped", func() {
+			addr := ln.Addr().String()
+			ln.Close()
+			e.opts.QueueSize = 9
+			e.opts.Backoff, e.opts.MaxBackoff = time.Millisecond, time.Millisecond
+			So(e.Push(), ShouldNotBeNil)
+			So(len(e.queue), ShouldEqual, 6)
+			time.Sleep(2 * time.Millisecond)
+			_ = r.Add(REQUESTS, 1)
+			So(e.Push(), ShouldNotBeNil)
+			So(len(e.queue), ShouldEqual, 9)
+			So(e.queue[0].path, ShouldEqual, "myapp.uploads.endpoint._upload.requests")
+
+			Convey("And are sent once it's back", func() {
+				ln, lines = carbon(addr)
+				defer ln.Close()
+				time.Sleep(2 * time.Millisecond)
+				_ = r.Add(REQUESTS, 1)
+				So(e.Push(), ShouldBeNil)
+				So(e.queue, ShouldBeEmpty)
+				got := receive(lines, 9)
+				So(len(got), ShouldEqual, 9)
+				So(got[3], ShouldStartWith, "myapp.uploads.requests 7 ")
+			})
+		})
+
+		Convey("Reconnects back off, doubling up to the maximum", func() {
+			e.opts.Backoff, e.opts.MaxBackoff = time.Second, 3*time.Second
+			e.backoff()
+			So(e.wait, ShouldEqual, time.Second)
+			e.backoff()
+			So(e.wait, ShouldEqual, 2*time.Second)
+			e.backoff()
+			So(e.wait, ShouldEqual, 3*time.Second)
+			So(e.flush().Error(), ShouldContainSubstring, "is unreachable, will reconnect after")
+		})
+	})
+}
+
+// TestGraphitePickle confirms the pickle protocol's framing and opcodes
+func TestGraphitePickle(t *testing.T) {
+	Convey("Given a metric", t, func() {
+		b := pickle([]graphiteMetric{{"a.b", 1.5, 1640385223}})
+
+		Convey("It is framed with a big-endian length and pickles a list of tuples", func() {
+			So(binary.BigEndian.Uint32(b), ShouldEqual, len(b)-4)
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, math.Float64bits(1.5))
+			So(string(b[4:]), ShouldEqual,
+				"\x80\x02](X\x03\x00\x00\x00a.bI1640385223\nG"+string(value)+"\x86\x86e.")
+		})
+	})
+}
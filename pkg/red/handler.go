@@ -0,0 +1,144 @@
+package red
+
+// handler serves a Red's /red endpoint, which every service used to
+// write for itself, as in "Transactions Times" in Red.md.
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// HandlerOptions are the choices for a Handler. The zero value is
+// always healthy, and needs no authorization.
+type HandlerOptions struct {
+	Healthy         func(now *Red) bool // chooses the status, by default always true
+	UnhealthyStatus int                 // the status when Healthy is false, by default 500
+	Username        string              // if set, require basic auth with this user
+	Password        string              // and this password
+	BearerToken     string              // if set, require this bearer token
+}
+
+// Formats a Handler serves, chosen by ?format= or the Accept header
+const (
+	FormatText        = "text"        // the comma format, as in 3, 1, 3.000667s
+	FormatWire        = "wire"        // a Sample, with the server's instance, epoch and sequence
+	FormatJSON        = "json"        // MarshalJSON's
+	FormatPrometheus  = "prometheus"  // the Prometheus text format, with every series
+	FormatOpenMetrics = "openmetrics" // OpenMetrics, with every series
+)
+
+// Handler returns an http.Handler that reports r, with 200 if it's
+// healthy and UnhealthyStatus if it isn't. If both basic and bearer
+// auth are set, either will do.
+func Handler(r *Red, opts HandlerOptions) http.Handler {
+	if opts.UnhealthyStatus == 0 {
+		opts.UnhealthyStatus = http.StatusInternalServerError
+	}
+	return &handler{r: r, opts: opts}
+}
+
+// handler is the Red and options a Handler serves
+type handler struct {
+	r    *Red
+	opts HandlerOptions
+}
+
+// ServeHTTP writes r in the format the request asks for
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !h.authorized(req) {
+		if h.opts.Username != "" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="red"`)
+		}
+		if h.opts.BearerToken != "" {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="red"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = negotiate(req.Header.Get("Accept"))
+	}
+
+	now := h.r.Now()
+	status := http.StatusOK
+	if h.opts.Healthy != nil && !h.opts.Healthy(now) {
+		status = h.opts.UnhealthyStatus
+	}
+
+	switch format {
+	case FormatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(now.String()))
+	case FormatWire:
+		sample := h.r.sample(now)
+		w.Header().Set("Content-Type", WireContentType)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(sample.String() + "\n"))
+	case FormatJSON:
+		j, err := now.MarshalJSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(j)
+	case FormatPrometheus, FormatOpenMetrics:
+		openMetrics := format == FormatOpenMetrics
+		if openMetrics {
+			w.Header().Set("Content-Type", OpenMetricsContentType)
+		} else {
+			w.Header().Set("Content-Type", PrometheusContentType)
+		}
+		w.WriteHeader(status)
+		_ = WritePrometheus(w, h.r.NowSeries(), openMetrics)
+	default:
+		http.Error(w, "unknown format "+format+", please use text, wire, json, prometheus or openmetrics",
+			http.StatusBadRequest)
+	}
+}
+
+// authorized reports whether req has the basic or bearer auth h needs
+func (h *handler) authorized(req *http.Request) bool {
+	if h.opts.Username == "" && h.opts.BearerToken == "" {
+		return true
+	}
+	if h.opts.Username != "" {
+		if user, password, ok := req.BasicAuth(); ok &&
+			equal(user, h.opts.Username) && equal(password, h.opts.Password) {
+			return true
+		}
+	}
+	if h.opts.BearerToken != "" {
+		auth := req.Header.Get("Authorization")
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok && equal(token, h.opts.BearerToken) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares secrets in constant time
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// negotiate picks a format from an Accept header, or the comma format
+// if it doesn't ask for anything we know
+func negotiate(accept string) string {
+	switch {
+	case strings.Contains(accept, "text/vnd.red"):
+		return FormatWire
+	case strings.Contains(accept, "application/json"):
+		return FormatJSON
+	case acceptsOpenMetrics(accept):
+		return FormatOpenMetrics
+	case strings.Contains(accept, "version=0.0.4"):
+		return FormatPrometheus
+	default:
+		return FormatText
+	}
+}
@@ -0,0 +1,99 @@
+package red
+
+// handler_test is GoConvey tests of the /red handler
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// get requests target from h, with headers, and returns the status, body and content type
+func get(h http.Handler, target string, headers ...string) (int, string, string) {
+	req := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body), rec.Header().Get("Content-Type")
+}
+
+// TestHandler confirms the handler negotiates formats, maps health and checks auth
+func TestHandler(t *testing.T) {
+	Convey("Given a red and a handler for it", t, func() {
+		r := NewRegistry().NewWithOptions("uploads", Options{Timing: BusyTime})
+		_ = r.Add(REQUESTS, 3)
+		_ = r.Add(ERRORS, 1)
+		healthy := true
+		h := Handler(r, HandlerOptions{Healthy: func(now *Red) bool { return healthy }})
+
+		Convey("By default it serves the comma format", func() {
+			status, body, contentType := get(h, "/red")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "3, 1, 0.000000s")
+			So(contentType, ShouldStartWith, "text/plain")
+		})
+
+		Convey("It serves JSON if the Accept header asks for it", func() {
+			_, body, contentType := get(h, "/red", "Accept", "application/json")
+			So(body, ShouldStartWith, `{"version":1,"requests":3,"errors":1,`)
+			So(contentType, ShouldEqual, "application/json")
+		})
+
+		Convey("It serves Prometheus or OpenMetrics if the Accept header asks for them", func() {
+			_, body, contentType := get(h, "/red", "Accept", "text/plain;version=0.0.4")
+			So(body, ShouldContainSubstring, "uploads_requests_total 3\n")
+			So(contentType, ShouldEqual, PrometheusContentType)
+			_, body, contentType = get(h, "/red", "Accept", "application/openmetrics-text")
+			So(body, ShouldEndWith, "# EOF\n")
+			So(contentType, ShouldEqual, OpenMetricsContentType)
+		})
+
+		Convey("It serves the wire format if the Accept header asks for it", func() {
+			_, body, contentType := get(h, "/red", "Accept", WireContentType)
+			So(contentType, ShouldEqual, WireContentType)
+			var s Sample
+			So(s.UnmarshalText([]byte(body)), ShouldBeNil)
+			So(s.Name, ShouldEqual, "uploads")
+			So(s.Red.Requests, ShouldEqual, 3)
+		})
+
+		Convey("The format parameter wins over the Accept header", func() {
+			_, body, _ := get(h, "/red?format=text", "Accept", "application/json")
+			So(body, ShouldEqual, "3, 1, 0.000000s")
+			status, _, _ := get(h, "/red?format=xml")
+			So(status, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("The health predicate chooses the status", func() {
+			healthy = false
+			status, body, _ := get(h, "/red")
+			So(status, ShouldEqual, http.StatusInternalServerError)
+			So(body, ShouldEqual, "3, 1, 0.000000s")
+			h = Handler(r, HandlerOptions{
+				Healthy:         func(now *Red) bool { return now.Errors == 0 },
+				UnhealthyStatus: http.StatusServiceUnavailable,
+			})
+			status, _, _ = get(h, "/red")
+			So(status, ShouldEqual, http.StatusServiceUnavailable)
+		})
+
+		Convey("With auth, it needs basic or bearer credentials", func() {
+			h = Handler(r, HandlerOptions{Username: "ops", Password: "secret", BearerToken: "t0ken"})
+			status, _, _ := get(h, "/red")
+			So(status, ShouldEqual, http.StatusUnauthorized)
+			status, _, _ = get(h, "/red", "Authorization", "Basic b3BzOndyb25n") // ops:wrong
+			So(status, ShouldEqual, http.StatusUnauthorized)
+			status, _, _ = get(h, "/red", "Authorization", "Basic b3BzOnNlY3JldA==") // ops:secret
+			So(status, ShouldEqual, http.StatusOK)
+			status, _, _ = get(h, "/red", "Authorization", "Bearer t0ken")
+			So(status, ShouldEqual, http.StatusOK)
+			status, _, _ = get(h, "/red", "Authorization", "Bearer nope")
+			So(status, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
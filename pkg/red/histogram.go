@@ -0,0 +1,168 @@
+package red
+
+// histogram records durations passed to Observe in log-linear buckets:
+// each power of two is split into 32 linear buckets, so any percentile
+// read from it is within about 1.6% of the true value.
+
+import (
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+const (
+	subBits    = 5            // the log2 of the number of buckets per power of two
+	subBuckets = 1 << subBits // the number of buckets per power of two
+	// nBuckets covers every positive int64, which is the largest shift,
+	// 63-(subBits+1), times subBuckets, plus the 2*subBuckets that fit in it
+	nBuckets = (63-subBits-1)*subBuckets + 2*subBuckets
+)
+
+// LatencyBuckets are the upper bounds of the Buckets in a Latency, the
+// same as Prometheus' defaults. Change them before creating any Reds.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond,
+	5 * time.Second, 10 * time.Second,
+}
+
+// Latency is a summary of the durations passed to Observe
+type Latency struct {
+	Count   int64         `json:"count"`
+	Sum     time.Duration `json:"sum"`
+	P50     time.Duration `json:"p50"`
+	P90     time.Duration `json:"p90"`
+	P99     time.Duration `json:"p99"`
+	Max     time.Duration `json:"max"`
+	Buckets []Bucket      `json:"-"` // for exporters, one per LatencyBuckets
+}
+
+// Bucket is the number of observed durations up to an upper bound,
+// to within the error of the histogram
+type Bucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+// String converts l into the part of a Red's String() after the duration
+func (l *Latency) String() string {
+	return fmt.Sprintf("p50 %fs, p90 %fs, p99 %fs, max %fs",
+		l.P50.Seconds(), l.P90.Seconds(), l.P99.Seconds(), l.Max.Seconds())
+}
+
+// Observe records the duration of one operation, so that Now() can
+// report percentiles of them. It doesn't wait for the worker.
+func (r *Red) Observe(d time.Duration) error {
+	if r == nil || r.inst == nil {
+		return fmt.Errorf("r is nil, please call Start() first")
+	}
+	if d < 0 {
+		return fmt.Errorf("usage error, negative duration %s for Observe", d)
+	}
+	return r.inst.do(msg{operation: observe, series: r.ser, value: int64(d)})
+}
+
+// histogram is only touched by the worker, or under the backend's lock
+type histogram struct {
+	counts [nBuckets]int64
+	count  int64
+	sum    int64
+	max    int64
+}
+
+// record adds one value to h
+func (h *histogram) record(v int64) {
+	h.counts[bucketOf(v)]++
+	h.count++
+	h.sum += v
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// quantile returns the value below which a fraction q of the values lie,
+// as the middle of the bucket it's in, but never more than the max.
+func (h *histogram) quantile(q float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			lo, hi := bucketBounds(i)
+			if mid := lo + (hi-lo)/2; mid < h.max {
+				return mid
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// latency summarizes h, or returns nil if nothing was observed
+func (h *histogram) latency() *Latency {
+	if h == nil || h.count == 0 {
+		return nil
+	}
+	return &Latency{
+		Count:   h.count,
+		Sum:     time.Duration(h.sum),
+		P50:     time.Duration(h.quantile(0.50)),
+		P90:     time.Duration(h.quantile(0.90)),
+		P99:     time.Duration(h.quantile(0.99)),
+		Max:     time.Duration(h.max),
+		Buckets: h.buckets(LatencyBuckets),
+	}
+}
+
+// buckets returns the cumulative counts up to each of bounds. A bucket
+// of h that straddles a bound is counted as above it.
+func (h *histogram) buckets(bounds []time.Duration) []Bucket {
+	out := make([]Bucket, len(bounds))
+	for j, bound := range bounds {
+		out[j].UpperBound = bound
+	}
+	var seen int64
+	j := 0
+	for i, n := range h.counts {
+		if n == 0 {
+			continue
+		}
+		_, hi := bucketBounds(i)
+		for ; j < len(bounds) && int64(bounds[j]) < hi; j++ {
+			out[j].Count = seen
+		}
+		seen += n
+	}
+	for ; j < len(bounds); j++ {
+		out[j].Count = seen
+	}
+	return out
+}
+
+// bucketOf maps v to a bucket. Values below 2*subBuckets get a bucket
+// each, larger ones share one with the others that have the same top
+// subBits+1 bits.
+func bucketOf(v int64) int {
+	shift := bits.Len64(uint64(v)) - (subBits + 1)
+	if shift < 0 {
+		shift = 0
+	}
+	return shift*subBuckets + int(uint64(v)>>uint(shift))
+}
+
+// bucketBounds returns the smallest and largest values in bucket i
+func bucketBounds(i int) (int64, int64) {
+	shift := i/subBuckets - 1
+	if shift < 0 {
+		return int64(i), int64(i)
+	}
+	top := uint64(i - shift*subBuckets)
+	return int64(top << uint(shift)), int64((top+1)<<uint(shift) - 1)
+}
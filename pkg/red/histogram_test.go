@@ -0,0 +1,69 @@
+package red
+
+// histogram_test is GoConvey tests of Observe and the latency percentiles
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHistogramBuckets confirms every value lands in a bucket that holds it
+func TestHistogramBuckets(t *testing.T) {
+	Convey("Given values across the whole range of an int64", t, func() {
+		for _, v := range []int64{0, 1, 31, 32, 63, 64, 65, 1000, 123456789, math.MaxInt64} {
+			lo, hi := bucketBounds(bucketOf(v))
+			So(lo <= v && v <= hi, ShouldBeTrue)
+			// the relative error is bounded by the width of the bucket
+			So(float64(hi-lo), ShouldBeLessThanOrEqualTo, float64(v)/subBuckets)
+		}
+		So(bucketOf(math.MaxInt64), ShouldEqual, nBuckets-1)
+	})
+}
+
+// TestObserve confirms Now() reports percentiles within the bucket error
+func TestObserve(t *testing.T) {
+	var r *Red
+
+	Convey("Given a red that has observed 1ms to 1000ms", t, func() {
+		r = New("observed").Start()
+		for i := 1; i <= 1000; i++ {
+			_ = r.Observe(time.Duration(i) * time.Millisecond)
+		}
+		now := r.Now()
+
+		Convey("Now() reports the percentiles and max", func() {
+			So(now.Latency, ShouldNotBeNil)
+			So(now.Latency.Count, ShouldEqual, 1000)
+			So(float64(now.Latency.P50), ShouldAlmostEqual, float64(500*time.Millisecond), float64(500*time.Millisecond)/64)
+			So(float64(now.Latency.P90), ShouldAlmostEqual, float64(900*time.Millisecond), float64(900*time.Millisecond)/64)
+			So(float64(now.Latency.P99), ShouldAlmostEqual, float64(990*time.Millisecond), float64(990*time.Millisecond)/64)
+			So(now.Latency.Max, ShouldEqual, time.Second)
+		})
+
+		Convey("String() and MarshalJSON() include them", func() {
+			So(now.String(), ShouldContainSubstring, ", p50 0.4")
+			So(now.String(), ShouldEndWith, "max 1.000000s")
+			j, err := now.MarshalJSON()
+			So(err, ShouldBeNil)
+			var decoded Red
+			So(json.Unmarshal(j, &decoded), ShouldBeNil)
+			// the buckets are for exporters, not json
+			expected := *now.Latency
+			expected.Buckets = nil
+			So(*decoded.Latency, ShouldResemble, expected)
+		})
+
+		Convey("Start() clears them", func() {
+			So(r.Start().Now().Latency, ShouldBeNil)
+			So(strings.Count(r.Now().String(), ","), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a negative duration, Observe returns an error", t, func() {
+		So(r.Observe(-time.Second), ShouldNotBeNil)
+	})
+}
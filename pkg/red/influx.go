@@ -0,0 +1,253 @@
+package red
+
+// influx writes Reds to InfluxDB in its line protocol, as described in
+// "Computing Rates" in Red.md: totals are written every minute or
+// hour, and Grafana plots the differences, which are the rates.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxOptions are the choices for an Influx writer. The zero value
+// writes everything in one batch every minute, to a measurement with
+// the Red's name, retrying three times.
+type InfluxOptions struct {
+	Measurement string            // by default, the Red's name
+	Tags        map[string]string // added to every line, as in host=a1
+	BatchSize   int               // the most lines in one write, by default all of them
+	Retries     int               // how many times to retry a failed write, by default 3
+	Backoff     time.Duration     // the first wait before a retry, doubled each time, by default 1 second
+	Interval    time.Duration     // how often Run writes, by default 1 minute
+}
+
+// InfluxWriter writes the totals of every series of a Red as lines of
+// the form
+//
+//	uploads,endpoint=/upload requests=45225i,errors=0i,duration_seconds=1563.56 1640385223487000000
+type InfluxWriter struct {
+	r        *Red
+	opts     InfluxOptions
+	send     func([]byte) error
+	maxBytes int       // the largest batch a UDP packet can hold, or 0
+	conn     io.Closer // the UDP socket, or nil
+}
+
+// NewInfluxWriter creates a writer that writes to w
+func NewInfluxWriter(r *Red, w io.Writer, opts InfluxOptions) *InfluxWriter {
+	return newInfluxWriter(r, opts, func(b []byte) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// NewInfluxUDPWriter creates a writer that sends to an Influx UDP
+// listener at addr, with batches no bigger than a packet. Its socket is
+// closed by Close(), or when Run returns.
+func NewInfluxUDPWriter(r *Red, addr string, opts InfluxOptions) (*InfluxWriter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("can't create an influx socket for %q, %w", addr, err)
+	}
+	w := newInfluxWriter(r, opts, func(b []byte) error {
+		_, err := conn.Write(b)
+		return err
+	})
+	w.maxBytes = 1432
+	w.conn = conn
+	return w, nil
+}
+
+// NewInfluxHTTPWriter creates a writer that posts to an Influx write
+// endpoint, such as http://localhost:8086/write?db=red
+func NewInfluxHTTPWriter(r *Red, url string, opts InfluxOptions) *InfluxWriter {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return newInfluxWriter(r, opts, func(b []byte) error {
+		resp, err := client.Post(url, "text/plain; charset=utf-8", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}()
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+			return fmt.Errorf("influx write to %q failed with status code %d", url, resp.StatusCode)
+		default:
+			// retrying won't make a bad request good
+			return permanent{fmt.Errorf("influx write to %q was rejected with status code %d", url, resp.StatusCode)}
+		}
+	})
+}
+
+// newInfluxWriter fills in the defaults
+func newInfluxWriter(r *Red, opts InfluxOptions, send func([]byte) error) *InfluxWriter {
+	if opts.Measurement == "" {
+		opts.Measurement = r.Name()
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	return &InfluxWriter{r: r, opts: opts, send: send}
+}
+
+// Run writes every interval until ctx is done, then writes once more,
+// without waiting to retry, and closes the socket if there is one.
+// Failed writes are logged, and the next interval's has the new totals.
+func (w *InfluxWriter) Run(ctx context.Context) error {
+	tick := time.NewTicker(w.opts.Interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := w.write(ctx); err != nil {
+				log.Printf("influx writer for %q: write failed. Message was %q\n", w.r.Name(), err)
+			}
+		case <-ctx.Done():
+			err := w.write(ctx)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+	}
+}
+
+// Write writes the current totals of every series, in batches, retrying
+// each batch with backoff if it fails.
+func (w *InfluxWriter) Write() error {
+	return w.write(context.Background())
+}
+
+// Close closes the writer's UDP socket, if it has one
+func (w *InfluxWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
+
+// write is Write, giving up on retries once ctx is done
+func (w *InfluxWriter) write(ctx context.Context) error {
+	t := time.Now()
+	lines := InfluxLines(w.r.NowSeries(), w.opts.Measurement, w.opts.Tags, t)
+
+	for _, batch := range w.batches(lines) {
+		if err := w.retry(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batches splits lines into writes of at most BatchSize lines and maxBytes bytes
+func (w *InfluxWriter) batches(lines []string) [][]byte {
+	var out [][]byte
+	var b bytes.Buffer
+	n := 0
+
+	for _, line := range lines {
+		full := w.opts.BatchSize > 0 && n == w.opts.BatchSize
+		if w.maxBytes > 0 && b.Len()+len(line)+1 > w.maxBytes {
+			full = true
+		}
+		if full && n > 0 {
+			out = append(out, append([]byte(nil), b.Bytes()...))
+			b.Reset()
+			n = 0
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+		n++
+	}
+	if n > 0 {
+		out = append(out, b.Bytes())
+	}
+	return out
+}
+
+// retry sends batch, and retries with backoff unless the error is
+// permanent or ctx is done
+func (w *InfluxWriter) retry(ctx context.Context, batch []byte) error {
+	var p permanent
+
+	wait := w.opts.Backoff
+	err := w.send(batch)
+	for i := 0; i < w.opts.Retries && err != nil && !errors.As(err, &p); i++ {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		wait *= 2
+		err = w.send(batch)
+	}
+	return err
+}
+
+// permanent is an error that retrying won't fix
+type permanent struct {
+	error
+}
+
+// InfluxLines formats reds, usually from NowSeries(), as line protocol,
+// one line each, with the series' labels and tags as tags, and t as
+// the timestamp in nanoseconds.
+func InfluxLines(reds []*Red, measurement string, tags map[string]string, t time.Time) []string {
+	var extra Labels
+	for name, value := range tags {
+		extra = append(extra, Label{name, value})
+	}
+	sort.Slice(extra, func(i, j int) bool {
+		return extra[i].Name < extra[j].Name
+	})
+
+	lines := make([]string, 0, len(reds))
+	for _, r := range reds {
+		var b strings.Builder
+		b.WriteString(measurementEscaper.Replace(measurement))
+		// Influx wants the tags sorted by name, which merge does
+		for _, l := range extra.merge(r.Labels) {
+			if l.Value == "" {
+				// an empty tag value isn't allowed
+				continue
+			}
+			b.WriteByte(',')
+			b.WriteString(tagKeyEscaper.Replace(l.Name))
+			b.WriteByte('=')
+			b.WriteString(tagKeyEscaper.Replace(l.Value))
+		}
+		fmt.Fprintf(&b, " requests=%di,errors=%di,duration_seconds=%s %d",
+			r.Requests, r.Errors, strconv.FormatFloat(r.Duration.Seconds(), 'f', -1, 64), t.UnixNano())
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// measurementEscaper and tagKeyEscaper escape what the line protocol requires
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagKeyEscaper      = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
@@ -0,0 +1,143 @@
+package red
+
+// influx_test is GoConvey tests of the Influx line-protocol writer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestInfluxLines confirms the line protocol is formatted and escaped
+func TestInfluxLines(t *testing.T) {
+	Convey("Given some series", t, func() {
+		at := time.Unix(1640385223, 487000000)
+		reds := []*Red{
+			{Requests: 45225, Duration: 1563560 * time.Millisecond},
+			{Requests: 3, Errors: 1, Duration: 1500 * time.Millisecond,
+				Labels: Labels{{"endpoint", "/up load"}, {"method", "POST"}}},
+		}
+
+		Convey("Each becomes one line, with tags sorted and escaped", func() {
+			lines := InfluxLines(reds, "my uploads", map[string]string{"host": "a1", "dc": "x,y"}, at)
+			So(lines, ShouldResemble, []string{
+				`my\ uploads,dc=x\,y,host=a1 requests=45225i,errors=0i,duration_seconds=1563.56 1640385223487000000`,
+				`my\ uploads,dc=x\,y,endpoint=/up\ load,host=a1,method=POST requests=3i,errors=1i,duration_seconds=1.5 1640385223487000000`,
+			})
+		})
+	})
+}
+
+// TestInfluxWriter confirms the writers batch and retry
+func TestInfluxWriter(t *testing.T) {
+	Convey("Given a red with three series", t, func() {
+		r := NewRegistry().NewWithOptions("uploads", Options{Timing: BusyTime})
+		_ = r.Add(REQUESTS, 1)
+		_ = r.With("method", "GET").Add(REQUESTS, 2)
+		_ = r.With("method", "PUT").Add(ERRORS, 3)
+
+		Convey("An io.Writer gets them in batches of BatchSize", func() {
+			var buf bytes.Buffer
+			var writes int
+			w := NewInfluxWriter(r, writerFunc(func(p []byte) (int, error) {
+				writes++
+				return buf.Write(p)
+			}), InfluxOptions{BatchSize: 2})
+			So(w.Write(), ShouldBeNil)
+			So(writes, ShouldEqual, 2)
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			So(len(lines), ShouldEqual, 3)
+			So(lines[0], ShouldStartWith, "uploads requests=1i,errors=0i,duration_seconds=0 ")
+			So(lines[1], ShouldStartWith, "uploads,method=GET requests=2i,")
+			So(lines[2], ShouldStartWith, "uploads,method=PUT requests=0i,errors=3i,")
+		})
+
+		Convey("An HTTP endpoint is retried until it succeeds", func() {
+			var mu sync.Mutex
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				mu.Lock()
+				defer mu.Unlock()
+				bodies = append(bodies, string(body))
+				if len(bodies) < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			w := NewInfluxHTTPWriter(r, server.URL+"/write?db=red", InfluxOptions{Backoff: time.Millisecond})
+			So(w.Write(), ShouldBeNil)
+			So(len(bodies), ShouldEqual, 3)
+			So(strings.Count(bodies[2], "\n"), ShouldEqual, 3)
+		})
+
+		Convey("An HTTP endpoint isn't retried when it rejects the write", func() {
+			var calls int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusBadRequest)
+			}))
+			defer server.Close()
+
+			w := NewInfluxHTTPWriter(r, server.URL+"/write?db=red", InfluxOptions{Backoff: time.Millisecond})
+			err := w.Write()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "400")
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("A UDP listener gets them in packet-sized batches", func() {
+			conn, read := listen()
+			defer conn.Close()
+			w, err := NewInfluxUDPWriter(r, conn.LocalAddr().String(), InfluxOptions{})
+			So(err, ShouldBeNil)
+			So(w.Write(), ShouldBeNil)
+			packets := read()
+			So(len(packets), ShouldEqual, 1)
+			So(strings.Count(packets[0], "\n"), ShouldEqual, 3)
+		})
+
+		Convey("Closing a UDP writer closes its socket", func() {
+			conn, _ := listen()
+			defer conn.Close()
+			w, err := NewInfluxUDPWriter(r, conn.LocalAddr().String(), InfluxOptions{Backoff: time.Millisecond})
+			So(err, ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+			So(w.Write(), ShouldNotBeNil)
+		})
+
+		Convey("Run stops waiting to retry when it's stopped", func() {
+			failing := writerFunc(func(p []byte) (int, error) { return 0, errors.New("unavailable") })
+			w := NewInfluxWriter(r, failing, InfluxOptions{Interval: 10 * time.Millisecond, Backoff: time.Hour})
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- w.Run(ctx) }()
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			select {
+			case err := <-done:
+				So(err, ShouldNotBeNil)
+			case <-time.After(time.Second):
+				So("Run was still retrying", ShouldBeEmpty)
+			}
+		})
+	})
+}
+
+// writerFunc makes a function into an io.Writer
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,41 @@
+package red
+
+// interval is for reporters that want to know exactly what happened in
+// each minute or hour, rather than subtracting one total from another.
+
+import (
+	"fmt"
+)
+
+// Swap returns the counts of r's series since it was started or last
+// swapped, and starts a new interval, in one operation of the worker,
+// so no add is lost or counted twice. The interval ran from the
+// StartTime to the EndTime of the returned Red.
+func (r *Red) Swap() (*Red, error) {
+	if r == nil || r.inst == nil {
+		return nil, fmt.Errorf("r is nil, please call Start() first")
+	}
+	tmp, err := r.call(swap, NONE, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &tmp, nil
+}
+
+// SwapSeries is Swap() for each series that has the labels in kv, or
+// for every series if there are none, with them all ending at the
+// same instant. Like NowSeries, they're sorted by their labels.
+func (r *Red) SwapSeries(kv ...string) ([]*Red, error) {
+	if r == nil || r.inst == nil {
+		return nil, fmt.Errorf("r is nil, please call Start() first")
+	}
+	a, err := r.ask(msg{operation: swapseries, labels: makeLabels(kv)})
+	if err != nil {
+		return nil, err
+	}
+	reds := make([]*Red, len(a.all))
+	for i := range a.all {
+		reds[i] = &a.all[i]
+	}
+	return reds, nil
+}
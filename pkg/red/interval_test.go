@@ -0,0 +1,83 @@
+package red
+
+// interval_test is GoConvey tests of Swap and SwapSeries
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSwap confirms intervals neither lose nor double-count adds
+func TestSwap(t *testing.T) {
+	for _, backend := range []Backend{Channel, Mutex, Sharded} {
+		Convey(fmt.Sprintf("Given a %s red being added to while it's swapped", backend), t, func() {
+			r := NewRegistry().NewWithOptions("swap", Options{Backend: backend})
+			b, _ := r.Batched(7, time.Millisecond)
+			var wg sync.WaitGroup
+			var intervals []*Red
+
+			wg.Add(8)
+			for i := 0; i < 8; i++ {
+				go func(h *Red) {
+					defer wg.Done()
+					for j := 0; j < 1000; j++ {
+						_ = h.Add(REQUESTS, 1)
+					}
+				}([]*Red{r, b}[i%2])
+			}
+			for i := 0; i < 20; i++ {
+				interval, err := r.Swap()
+				So(err, ShouldBeNil)
+				intervals = append(intervals, interval)
+			}
+			wg.Wait()
+			last, _ := r.Swap()
+			intervals = append(intervals, last)
+
+			Convey("The intervals add up to every add, exactly", func() {
+				var total int64
+				for _, interval := range intervals {
+					total += interval.Requests
+				}
+				So(total, ShouldEqual, 8000)
+			})
+
+			Convey("Each interval starts when the last one ended", func() {
+				for i := 1; i < len(intervals); i++ {
+					So(intervals[i].StartTime, ShouldEqual, intervals[i-1].EndTime)
+					So(intervals[i].Duration, ShouldEqual, intervals[i].EndTime.Sub(intervals[i].StartTime))
+				}
+			})
+		})
+	}
+
+	Convey("Given a red with labelled series", t, func() {
+		r := NewRegistry().New("swap-series")
+		_ = r.With("endpoint", "/upload").Add(REQUESTS, 2)
+		_ = r.With("endpoint", "/health").Add(REQUESTS, 1)
+		_ = r.With("endpoint", "/health").Observe(time.Millisecond)
+
+		Convey("SwapSeries ends the matching ones at the same instant", func() {
+			all, err := r.SwapSeries("endpoint", "/health")
+			So(err, ShouldBeNil)
+			So(len(all), ShouldEqual, 1)
+			So(all[0].Requests, ShouldEqual, 1)
+			So(all[0].Latency.Count, ShouldEqual, 1)
+			So(r.With("endpoint", "/health").Now().Latency, ShouldBeNil)
+			So(r.With("endpoint", "/upload").Now().Requests, ShouldEqual, 2)
+
+			all, _ = r.SwapSeries()
+			So(len(all), ShouldEqual, 3)
+			So(all[1].EndTime, ShouldEqual, all[2].EndTime)
+		})
+	})
+
+	Convey("Given a nil red, Swap returns an error", t, func() {
+		var r *Red
+		_, err := r.Swap()
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,226 @@
+package red
+
+// json converts Reds to and from JSON, in a schema that keeps everything
+// a Red knows, in units a person can read
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONVersion is the version of the schema MarshalJSON writes
+const JSONVersion = 1
+
+// jsonRed is version 1 of the schema. Durations are in seconds, written
+// as exact decimals so no nanoseconds are lost, and times are RFC 3339
+// with nanoseconds. Everything after duration_seconds is left out if
+// it's zero.
+//
+//	{"version":1,"requests":45225,"errors":0,"duration_seconds":1563.562311863,
+//	 "start_time":"2021-12-24T17:07:39.925-05:00",
+//	 "latency":{"count":45225,"sum_seconds":1502.7,"p50_seconds":0.031,...},
+//	 "elapsed_seconds":1600.1,"labels":{"endpoint":"/upload"},
+//	 "end_time":"2021-12-24T17:33:43.487-05:00"}
+type jsonRed struct {
+	Version   int               `json:"version"`
+	Requests  int64             `json:"requests"`
+	Errors    int64             `json:"errors"`
+	Duration  json.Number       `json:"duration_seconds"`
+	StartTime string            `json:"start_time,omitempty"`
+	Latency   *jsonLatency      `json:"latency,omitempty"`
+	Elapsed   json.Number       `json:"elapsed_seconds,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	EndTime   string            `json:"end_time,omitempty"`
+}
+
+// jsonLatency is a Latency in version 1 of the schema. Its Buckets are
+// for exporters, and aren't included.
+type jsonLatency struct {
+	Count int64       `json:"count"`
+	Sum   json.Number `json:"sum_seconds"`
+	P50   json.Number `json:"p50_seconds"`
+	P90   json.Number `json:"p90_seconds"`
+	P99   json.Number `json:"p99_seconds"`
+	Max   json.Number `json:"max_seconds"`
+}
+
+// legacyRed is the schema before versions: just the counts, with the
+// duration and latencies in nanoseconds
+type legacyRed struct {
+	Requests  int64         `json:"requests"`
+	Errors    int64         `json:"errors"`
+	Duration  time.Duration `json:"duration"`
+	StartTime time.Time     `json:"start_time"`
+	Latency   *Latency      `json:"latency"`
+}
+
+// MarshalJSON converts r into version 1 of the schema, described at
+// jsonRed. As with String(), call Now() first if you want to know the
+// Duration.
+func (r *Red) MarshalJSON() ([]byte, error) {
+	j := jsonRed{
+		Version:  JSONVersion,
+		Requests: r.Requests,
+		Errors:   r.Errors,
+		Duration: seconds(r.Duration),
+	}
+	var err error
+	if j.StartTime, err = timestamp(r.StartTime); err != nil {
+		return nil, err
+	}
+	if j.EndTime, err = timestamp(r.EndTime); err != nil {
+		return nil, err
+	}
+	if l := r.Latency; l != nil {
+		j.Latency = &jsonLatency{l.Count, seconds(l.Sum), seconds(l.P50), seconds(l.P90), seconds(l.P99), seconds(l.Max)}
+	}
+	if r.Elapsed != 0 {
+		j.Elapsed = seconds(r.Elapsed)
+	}
+	if len(r.Labels) > 0 {
+		j.Labels = make(map[string]string, len(r.Labels))
+		for _, l := range r.Labels {
+			j.Labels[l.Name] = l.Value
+		}
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON sets r's public fields from version 1 of the schema, or
+// from the unversioned one that came before it
+func (r *Red) UnmarshalJSON(b []byte) error {
+	var probe struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return err
+	}
+	switch {
+	case probe.Version == nil:
+		var old legacyRed
+		if err := json.Unmarshal(b, &old); err != nil {
+			return err
+		}
+		*r = Red{
+			Requests:  old.Requests,
+			Errors:    old.Errors,
+			Duration:  old.Duration,
+			StartTime: old.StartTime,
+			Latency:   old.Latency,
+			inst:      r.inst, ser: r.ser, batch: r.batch,
+		}
+		return nil
+	case *probe.Version != JSONVersion:
+		return fmt.Errorf("can't read a Red in version %d of the JSON schema, only %d", *probe.Version, JSONVersion)
+	}
+
+	var j jsonRed
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	tmp := Red{Requests: j.Requests, Errors: j.Errors, inst: r.inst, ser: r.ser, batch: r.batch}
+	var err error
+	if tmp.Duration, err = parseSeconds(j.Duration); err != nil {
+		return err
+	}
+	if tmp.Elapsed, err = parseSeconds(j.Elapsed); err != nil {
+		return err
+	}
+	if tmp.StartTime, err = parseTimestamp(j.StartTime); err != nil {
+		return err
+	}
+	if tmp.EndTime, err = parseTimestamp(j.EndTime); err != nil {
+		return err
+	}
+	if l := j.Latency; l != nil {
+		tmp.Latency = &Latency{Count: l.Count}
+		for _, x := range []struct {
+			to   *time.Duration
+			from json.Number
+		}{{&tmp.Latency.Sum, l.Sum}, {&tmp.Latency.P50, l.P50}, {&tmp.Latency.P90, l.P90},
+			{&tmp.Latency.P99, l.P99}, {&tmp.Latency.Max, l.Max}} {
+			if *x.to, err = parseSeconds(x.from); err != nil {
+				return err
+			}
+		}
+	}
+	if len(j.Labels) > 0 {
+		var kv []string
+		for name, value := range j.Labels {
+			kv = append(kv, name, value)
+		}
+		tmp.Labels = makeLabels(kv)
+	}
+	*r = tmp
+	return nil
+}
+
+// seconds writes d in seconds, as an exact decimal
+func seconds(d time.Duration) json.Number {
+	sign, u := "", uint64(d)
+	if d < 0 {
+		// -d overflows for the smallest Duration, but is still right as a uint64
+		sign, u = "-", uint64(-d)
+	}
+	s := sign + strconv.FormatUint(u/1e9, 10)
+	if frac := u % 1e9; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%09d", frac), "0")
+	}
+	return json.Number(s)
+}
+
+// parseSeconds reads what seconds wrote exactly, and any other number
+// of seconds to the nearest nanosecond. An empty one is zero.
+func parseSeconds(n json.Number) (time.Duration, error) {
+	s := string(n)
+	if s == "" {
+		return 0, nil
+	}
+	neg := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if len(frac) <= 9 && !strings.ContainsAny(s, "eE+") {
+		w, err := strconv.ParseUint(whole, 10, 64)
+		if err == nil && w <= math.MaxInt64/uint64(time.Second) {
+			f, _ := strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+			u := w*1e9 + f
+			switch {
+			case neg && u <= 1<<63:
+				return time.Duration(-u), nil
+			case !neg && u < 1<<63:
+				return time.Duration(u), nil
+			}
+		}
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, fmt.Errorf("can't read %q as a number of seconds, %w", s, err)
+	}
+	ns := math.Round(f * 1e9)
+	if ns < math.MinInt64 || ns >= math.MaxInt64 {
+		return 0, fmt.Errorf("%s seconds is too long for a time.Duration", s)
+	}
+	return time.Duration(ns), nil
+}
+
+// timestamp writes t in RFC 3339 with nanoseconds, or "" if it's zero
+func timestamp(t time.Time) (string, error) {
+	if t.IsZero() {
+		return "", nil
+	}
+	if y := t.Year(); y < 0 || y > 9999 {
+		return "", fmt.Errorf("can't write %v in RFC 3339, the year is outside [0,9999]", t)
+	}
+	return t.Format(time.RFC3339Nano), nil
+}
+
+// parseTimestamp reads what timestamp wrote
+func parseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
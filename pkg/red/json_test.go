@@ -0,0 +1,137 @@
+package red
+
+// json_test is GoConvey tests and fuzz tests of the JSON schema
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestJSON confirms the schema, and that the old one can still be read
+func TestJSON(t *testing.T) {
+	Convey("Given a Red with everything set", t, func() {
+		est := time.FixedZone("EST", -5*60*60)
+		r := &Red{
+			Requests:  45225,
+			Errors:    3,
+			Duration:  1563562311863,
+			StartTime: time.Date(2021, 12, 24, 17, 7, 39, 925000000, est),
+			Latency:   &Latency{Count: 2, Sum: 1500 * time.Millisecond, P50: 500 * time.Millisecond, Max: time.Second},
+			Labels:    Labels{{"endpoint", "/upload"}},
+		}
+
+		Convey("It's written with a version, seconds and RFC 3339 times", func() {
+			j, err := r.MarshalJSON()
+			So(err, ShouldBeNil)
+			So(string(j), ShouldEqual, `{"version":1,"requests":45225,"errors":3,"duration_seconds":1563.562311863,`+
+				`"start_time":"2021-12-24T17:07:39.925-05:00",`+
+				`"latency":{"count":2,"sum_seconds":1.5,"p50_seconds":0.5,"p90_seconds":0,"p99_seconds":0,"max_seconds":1},`+
+				`"labels":{"endpoint":"/upload"}}`)
+
+			Convey("And read back as the same value", func() {
+				var back Red
+				So(json.Unmarshal(j, &back), ShouldBeNil)
+				So(back.StartTime.Equal(r.StartTime), ShouldBeTrue)
+				back.StartTime = r.StartTime
+				So(back, ShouldResemble, *r)
+			})
+		})
+
+		Convey("The unversioned schema can still be read", func() {
+			var back Red
+			So(json.Unmarshal([]byte(`{"requests":3,"errors":1,"duration":3000667000,"latency":{"count":1,"max":5}}`), &back), ShouldBeNil)
+			So(back.Requests, ShouldEqual, 3)
+			So(back.Errors, ShouldEqual, 1)
+			So(back.Duration, ShouldEqual, 3000667000)
+			So(back.Latency.Max, ShouldEqual, 5)
+		})
+
+		Convey("Other versions, and bad numbers, are errors", func() {
+			var back Red
+			So(json.Unmarshal([]byte(`{"version":2,"requests":3}`), &back), ShouldNotBeNil)
+			So(json.Unmarshal([]byte(`{"version":1,"duration_seconds":1e300}`), &back), ShouldNotBeNil)
+		})
+
+		Convey("Seconds in other forms are read to the nearest nanosecond", func() {
+			var back Red
+			So(json.Unmarshal([]byte(`{"version":1,"duration_seconds":1.5e-3}`), &back), ShouldBeNil)
+			So(back.Duration, ShouldEqual, 1500*time.Microsecond)
+			So(json.Unmarshal([]byte(`{"version":1,"duration_seconds":0.0000000015}`), &back), ShouldBeNil)
+			So(back.Duration, ShouldEqual, 2)
+		})
+	})
+}
+
+// FuzzJSONRoundTrip confirms writing and reading a Red gives back the same value
+func FuzzJSONRoundTrip(f *testing.F) {
+	f.Add(int64(45225), int64(0), int64(1563562311863), int64(1640385223), int64(487000000), int64(0), "endpoint", "/upload")
+	f.Add(int64(-1), int64(math.MaxInt64), int64(math.MinInt64), int64(0), int64(0), int64(math.MaxInt64), "", "")
+	f.Fuzz(func(t *testing.T, requests, errors, duration, sec, nsec, elapsed int64, name, value string) {
+		// RFC 3339 only has four-digit years
+		sec = sec % (9999 * 365 * 24 * 60 * 60)
+		if sec < 0 {
+			sec = -sec
+		}
+		r := Red{
+			Requests:  requests,
+			Errors:    errors,
+			Duration:  time.Duration(duration),
+			StartTime: time.Unix(sec-62135596800, nsec%1e9+1e9).UTC(),
+			Elapsed:   time.Duration(elapsed),
+			Latency:   &Latency{Count: requests, Sum: time.Duration(duration), Max: time.Duration(elapsed)},
+		}
+		if !utf8.ValidString(name) || !utf8.ValidString(value) {
+			t.Skip("JSON strings are UTF-8")
+		}
+		if name != "" {
+			r.Labels = Labels{{name, value}}
+		}
+		j, err := r.MarshalJSON()
+		if err != nil {
+			t.Skipf("can't be written, %v", err)
+		}
+		var back Red
+		if err := json.Unmarshal(j, &back); err != nil {
+			t.Fatalf("can't read back %s, %v", j, err)
+		}
+		if !back.StartTime.Equal(r.StartTime) {
+			t.Fatalf("start time %v came back as %v", r.StartTime, back.StartTime)
+		}
+		back.StartTime = r.StartTime
+		if back.String() != r.String() || back.Elapsed != r.Elapsed || !reflect.DeepEqual(back.Latency, r.Latency) ||
+			len(back.Labels) != len(r.Labels) || (len(r.Labels) > 0 && back.Labels[0] != r.Labels[0]) {
+			t.Fatalf("%#v came back as %#v", r, back)
+		}
+	})
+}
+
+// FuzzJSONUnmarshal confirms reading anything doesn't panic, and that
+// whatever is read is written and read back the same
+func FuzzJSONUnmarshal(f *testing.F) {
+	f.Add([]byte(`{"version":1,"requests":3,"errors":1,"duration_seconds":3.000667,"start_time":"2021-12-24T17:07:39.925-05:00"}`))
+	f.Add([]byte(`{"requests":3,"errors":1,"duration":3000667000}`))
+	f.Add([]byte(`{"version":1,"duration_seconds":-9223372036.854775808,"labels":{"a":"b"}}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var r Red
+		if err := json.Unmarshal(b, &r); err != nil {
+			return
+		}
+		j, err := r.MarshalJSON()
+		if err != nil {
+			return
+		}
+		var back Red
+		if err := json.Unmarshal(j, &back); err != nil {
+			t.Fatalf("can't read back %s, %v", j, err)
+		}
+		j2, err := back.MarshalJSON()
+		if err != nil || string(j2) != string(j) {
+			t.Fatalf("%s came back as %s, %v", j, j2, err)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package red
+
+// log lets structured loggers record a Red as typed fields, rather
+// than as a string, a base64 blob or nanoseconds, as in Notes.md
+
+import (
+	"log/slog"
+	"time"
+)
+
+// LogValue makes r a slog.LogValuer, so
+//
+//	slog.Info("upload metrics", "red", r.Now())
+//
+// logs "red":{"requests":45225,"errors":0,"duration_seconds":1563.562311863,...}
+// with the start time, rates and any labels. As with String(), call
+// Now() first if you want to know the Duration.
+func (r *Red) LogValue() slog.Value {
+	if r == nil {
+		return slog.StringValue("r is nil, please call Start() first")
+	}
+	requests, errors := r.Rates()
+	attrs := []slog.Attr{
+		slog.Int64("requests", r.Requests),
+		slog.Int64("errors", r.Errors),
+		slog.Float64("duration_seconds", r.Duration.Seconds()),
+	}
+	if !r.StartTime.IsZero() {
+		attrs = append(attrs, slog.Time("start_time", r.StartTime))
+	}
+	attrs = append(attrs,
+		slog.Float64("requests_per_second", requests),
+		slog.Float64("errors_per_second", errors))
+	if len(r.Labels) > 0 {
+		labels := make([]any, len(r.Labels))
+		for i, l := range r.Labels {
+			labels[i] = slog.String(l.Name, l.Value)
+		}
+		attrs = append(attrs, slog.Group("labels", labels...))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Rates returns the requests and errors per second over r's Duration,
+// or zeros if it has none
+func (r *Red) Rates() (requests, errors float64) {
+	if r.Duration <= 0 {
+		return 0, 0
+	}
+	seconds := float64(r.Duration) / float64(time.Second)
+	return float64(r.Requests) / seconds, float64(r.Errors) / seconds
+}
@@ -0,0 +1,49 @@
+package red
+
+// log_test is GoConvey tests of structured logging
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestLogValue confirms a Red is logged as typed fields
+func TestLogValue(t *testing.T) {
+	Convey("Given a Red and a JSON slog logger", t, func() {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+		start := time.Date(2021, 12, 24, 17, 7, 39, 925000000, time.UTC)
+		r := &Red{Requests: 45225, Errors: 9, Duration: 1563562311863, StartTime: start}
+
+		Convey("It's logged as a group of numbers, not a string", func() {
+			logger.Info("upload metrics", "set", "BTO_experiment_NY", "red", r)
+			var line struct {
+				Red map[string]any `json:"red"`
+			}
+			So(json.Unmarshal(buf.Bytes(), &line), ShouldBeNil)
+			So(line.Red["requests"], ShouldEqual, 45225)
+			So(line.Red["errors"], ShouldEqual, 9)
+			So(line.Red["duration_seconds"], ShouldEqual, 1563.562311863)
+			So(line.Red["start_time"], ShouldEqual, "2021-12-24T17:07:39.925Z")
+			So(line.Red["requests_per_second"], ShouldAlmostEqual, 28.925, 0.001)
+			So(line.Red["errors_per_second"], ShouldAlmostEqual, 0.00576, 0.00001)
+			So(line.Red, ShouldNotContainKey, "labels")
+		})
+
+		Convey("A series' labels are logged too", func() {
+			r.Labels = Labels{{"endpoint", "/upload"}}
+			logger.Info("upload metrics", "red", r)
+			So(buf.String(), ShouldContainSubstring, `"labels":{"endpoint":"/upload"}`)
+		})
+
+		Convey("Without a duration, the rates are zero", func() {
+			requests, errors := (&Red{Requests: 3}).Rates()
+			So(requests, ShouldEqual, 0)
+			So(errors, ShouldEqual, 0)
+		})
+	})
+}
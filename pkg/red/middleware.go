@@ -0,0 +1,167 @@
+package red
+
+// middleware counts requests, errors and latencies for an http.Handler,
+// so its handlers needn't call Add() themselves.
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MiddlewareOptions are the choices for Middleware. The zero value
+// counts 5xx responses as errors, and labels by method and, if the
+// handler is a ServeMux, by its route pattern.
+type MiddlewareOptions struct {
+	IsError func(status int) bool          // whether a status is an error, by default status >= 500
+	Route   func(req *http.Request) string // the route label, by default the ServeMux pattern
+}
+
+// Middleware returns an http.Handler that calls next and counts each
+// request once, in the series of r labelled with its route and method.
+// Errors are counted by IsError, and each request's latency is passed
+// to Observe(). A handler that panics is counted as an error, then the
+// panic continues on to net/http.
+func Middleware(r *Red, opts MiddlewareOptions) func(next http.Handler) http.Handler {
+	if opts.IsError == nil {
+		opts.IsError = func(status int) bool {
+			return status >= 500
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		m := &middleware{r: r, opts: opts, next: next}
+		if m.opts.Route == nil {
+			if mux, ok := next.(*http.ServeMux); ok {
+				m.opts.Route = func(req *http.Request) string {
+					_, pattern := mux.Handler(req)
+					return pattern
+				}
+			}
+		}
+		return m
+	}
+}
+
+// middleware is what Middleware returns
+type middleware struct {
+	r       *Red
+	opts    MiddlewareOptions
+	next    http.Handler
+	handles sync.Map // from route and method to the series' handle
+}
+
+// ServeHTTP times next, and counts what it returned
+func (m *middleware) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	series := m.series(req)
+	t := series.Begin()
+	sw := &statusWriter{ResponseWriter: w}
+
+	defer func() {
+		var err error
+		p := recover()
+		if p != nil {
+			err = fmt.Errorf("handler panicked, %v", p)
+		} else if m.opts.IsError(sw.status()) {
+			err = fmt.Errorf("handler returned status %d", sw.status())
+		}
+		_ = series.Observe(time.Since(t.begun))
+		_ = t.End(err)
+		if p != nil {
+			panic(p)
+		}
+	}()
+	m.next.ServeHTTP(sw, req)
+}
+
+// series returns the handle for req's route and method, creating it if need be
+func (m *middleware) series(req *http.Request) *Red {
+	kv := []string{"method", method(req.Method)}
+	if m.opts.Route != nil {
+		kv = append(kv, "route", m.opts.Route(req))
+	}
+	key := kv[1]
+	if len(kv) > 2 {
+		key += " " + kv[3]
+	}
+	if h, ok := m.handles.Load(key); ok {
+		return h.(*Red)
+	}
+	h, _ := m.handles.LoadOrStore(key, m.r.With(kv...))
+	return h.(*Red)
+}
+
+// method returns the standard methods as they are, and any others as
+// OTHER, so a client can't create series at will
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return m
+	default:
+		return "OTHER"
+	}
+}
+
+// statusWriter remembers the status a handler wrote, and passes on
+// Flush for streaming responses and Hijack for websockets
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+// status is the status written, which is 200 if the handler wrote a
+// body without one, or only returned
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// WriteHeader remembers the first status written
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 && code >= 200 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write is an implicit WriteHeader(200)
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what's been written so far, if the underlying writer can
+func (w *statusWriter) Flush() {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler, which is counted as
+// switching protocols
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijack, %w", http.ErrNotSupported)
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController find the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
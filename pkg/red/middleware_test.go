@@ -0,0 +1,125 @@
+package red
+
+// middleware_test is GoConvey tests of the http middleware
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestMiddleware confirms requests, errors and latencies are counted per route and method
+func TestMiddleware(t *testing.T) {
+	Convey("Given a mux wrapped in the middleware", t, func() {
+		r := NewRegistry().NewWithOptions("api", Options{Timing: BusyTime})
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, req *http.Request) {
+			_, _ = w.Write([]byte("item"))
+		})
+		mux.HandleFunc("POST /items", func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "broken", http.StatusServiceUnavailable)
+		})
+		mux.HandleFunc("GET /panic", func(w http.ResponseWriter, req *http.Request) {
+			panic("oops")
+		})
+		mux.HandleFunc("GET /stream", func(w http.ResponseWriter, req *http.Request) {
+			for i := 0; i < 3; i++ {
+				_, _ = w.Write([]byte("chunk\n"))
+				w.(http.Flusher).Flush()
+			}
+		})
+		mux.HandleFunc("GET /ws", func(w http.ResponseWriter, req *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack failed, %v", err)
+				return
+			}
+			defer conn.Close()
+			_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+			_ = rw.Flush()
+		})
+		server := httptest.NewServer(Middleware(r, MiddlewareOptions{})(mux))
+		defer server.Close()
+		series := func(kv ...string) *Red {
+			reds := r.NowSeries(kv...)
+			if len(reds) != 1 {
+				return &Red{}
+			}
+			return reds[0]
+		}
+
+		Convey("Each request is counted once, in its route and method's series", func() {
+			for _, id := range []string{"1", "2", "3"} {
+				resp, err := http.Get(server.URL + "/items/" + id)
+				So(err, ShouldBeNil)
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			items := series("route", "GET /items/{id}", "method", "GET")
+			So(items.Requests, ShouldEqual, 3)
+			So(items.Errors, ShouldEqual, 0)
+			So(items.Latency.Count, ShouldEqual, 3)
+			So(items.Duration, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("A 5xx is counted as an error", func() {
+			resp, err := http.Post(server.URL+"/items", "text/plain", nil)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			post := series("route", "POST /items")
+			So(post.Requests, ShouldEqual, 1)
+			So(post.Errors, ShouldEqual, 1)
+		})
+
+		Convey("A panic is counted as an error, and still reaches net/http", func() {
+			_, err := http.Get(server.URL + "/panic")
+			So(err, ShouldNotBeNil) // net/http drops the connection
+			p := series("route", "GET /panic")
+			So(p.Requests, ShouldEqual, 1)
+			So(p.Errors, ShouldEqual, 1)
+		})
+
+		Convey("A streaming response is flushed through", func() {
+			resp, err := http.Get(server.URL + "/stream")
+			So(err, ShouldBeNil)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldEqual, "chunk\nchunk\nchunk\n")
+			So(series("route", "GET /stream").Requests, ShouldEqual, 1)
+		})
+
+		Convey("A hijacked connection is counted, and not as an error", func() {
+			resp, err := http.Get(server.URL + "/ws")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			// the handler may still be running when the response arrives
+			So(waitFor(func() bool { return series("route", "GET /ws").Requests == 1 }), ShouldBeTrue)
+			ws := series("route", "GET /ws")
+			So(ws.Requests, ShouldEqual, 1)
+			So(ws.Errors, ShouldEqual, 0)
+		})
+
+		Convey("The error rule can be changed", func() {
+			h := Middleware(r, MiddlewareOptions{
+				IsError: func(status int) bool { return status >= 400 },
+				Route:   func(req *http.Request) string { return "custom" },
+			})(http.NotFoundHandler())
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("BREW", "/pot", nil))
+			c := series("route", "custom")
+			So(c.Requests, ShouldEqual, 1)
+			So(c.Errors, ShouldEqual, 1)
+			So(c.Labels.Get("method"), ShouldEqual, "OTHER")
+		})
+
+		Convey("Without a hijacker underneath, Hijack returns an error", func() {
+			sw := &statusWriter{ResponseWriter: httptest.NewRecorder()}
+			_, _, err := sw.Hijack()
+			So(err, ShouldWrap, http.ErrNotSupported)
+		})
+	})
+}
@@ -0,0 +1,215 @@
+package red
+
+// prometheus serves Reds in the Prometheus text exposition format,
+// version 0.0.4, or in OpenMetrics 1.0 if the scraper asks for it.
+// It uses only the standard library, so it builds without network access.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PrometheusContentType is the content type of the text format
+	PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+	// OpenMetricsContentType is the content type of OpenMetrics
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// PrometheusHandler returns an http.Handler that serves every series of
+// each of reds to a Prometheus scraper. Requests and errors are
+// counters, as is the duration, in seconds, and the start time is a gauge.
+// Latencies from Observe() are histograms, with LatencyBuckets as buckets.
+func PrometheusHandler(reds ...*Red) http.Handler {
+	return prometheusHandler(reds)
+}
+
+// prometheusHandler is the Reds a PrometheusHandler serves
+type prometheusHandler []*Red
+
+// ServeHTTP writes the current values of h's Reds
+func (h prometheusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	openMetrics := acceptsOpenMetrics(req.Header.Get("Accept"))
+	if openMetrics {
+		w.Header().Set("Content-Type", OpenMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", PrometheusContentType)
+	}
+	var all []*Red
+	for _, r := range h {
+		all = append(all, r.NowSeries()...)
+	}
+	if err := WritePrometheus(w, all, openMetrics); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// acceptsOpenMetrics reports whether an Accept header asks for OpenMetrics
+func acceptsOpenMetrics(accept string) bool {
+	return strings.Contains(accept, "application/openmetrics-text")
+}
+
+// WritePrometheus writes reds, usually from NowSeries(), to w in the
+// Prometheus text format, or OpenMetrics if openMetrics is set. The
+// metric names start with the names of the Reds, with the series'
+// labels as labels.
+func WritePrometheus(w io.Writer, reds []*Red, openMetrics bool) error {
+	var b bytes.Buffer
+
+	// group the series by their Red, keeping them in order
+	var names []string
+	groups := make(map[string][]*Red)
+	for _, r := range reds {
+		name := metricName(r.Name())
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], r)
+	}
+
+	for _, name := range names {
+		group := groups[name]
+		writeCounter(&b, openMetrics, name+"_requests", "Requests received.", group, func(r *Red) string {
+			return strconv.FormatInt(r.Requests, 10)
+		})
+		writeCounter(&b, openMetrics, name+"_errors", "Requests that failed.", group, func(r *Red) string {
+			return strconv.FormatInt(r.Errors, 10)
+		})
+		writeCounter(&b, openMetrics, name+"_duration_seconds", "Duration, in seconds.", group, func(r *Red) string {
+			return formatFloat(r.Duration.Seconds())
+		})
+
+		family := name + "_start_time_seconds"
+		fmt.Fprintf(&b, "# HELP %s Start time, in seconds since the epoch.\n", family)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", family)
+		for _, r := range group {
+			fmt.Fprintf(&b, "%s%s %s\n", family, formatLabels(r.Labels), formatTime(r.StartTime))
+		}
+
+		writeHistogram(&b, openMetrics, name+"_latency_seconds", group)
+	}
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeCounter writes one counter family, with a sample from each Red
+func writeCounter(b *bytes.Buffer, openMetrics bool, family, help string, reds []*Red, value func(*Red) string) {
+	if openMetrics {
+		// the family has no _total, its samples do
+		fmt.Fprintf(b, "# HELP %s %s\n", family, help)
+		fmt.Fprintf(b, "# TYPE %s counter\n", family)
+		for _, r := range reds {
+			labels := formatLabels(r.Labels)
+			fmt.Fprintf(b, "%s_total%s %s\n", family, labels, value(r))
+			fmt.Fprintf(b, "%s_created%s %s\n", family, labels, formatTime(r.StartTime))
+		}
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s_total %s\n", family, help)
+	fmt.Fprintf(b, "# TYPE %s_total counter\n", family)
+	for _, r := range reds {
+		fmt.Fprintf(b, "%s_total%s %s\n", family, formatLabels(r.Labels), value(r))
+	}
+}
+
+// writeHistogram writes the latencies of those Reds that have any
+func writeHistogram(b *bytes.Buffer, openMetrics bool, family string, reds []*Red) {
+	header := false
+	for _, r := range reds {
+		l := r.Latency
+		if l == nil {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(b, "# HELP %s Observed latencies, in seconds.\n", family)
+			fmt.Fprintf(b, "# TYPE %s histogram\n", family)
+			header = true
+		}
+		series := histogramLabels(r.Labels)
+		for _, bucket := range l.Buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", family,
+				formatLabels(series, Label{"le", formatFloat(bucket.UpperBound.Seconds())}), bucket.Count)
+		}
+		labels := formatLabels(series)
+		fmt.Fprintf(b, "%s_bucket%s %d\n", family, formatLabels(series, Label{"le", "+Inf"}), l.Count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", family, labels, formatFloat(l.Sum.Seconds()))
+		fmt.Fprintf(b, "%s_count%s %d\n", family, labels, l.Count)
+		if openMetrics {
+			fmt.Fprintf(b, "%s_created%s %s\n", family, labels, formatTime(r.StartTime))
+		}
+	}
+}
+
+// histogramLabels renames a series' own le label to exported_le, as
+// client_golang does, so it doesn't clash with the buckets' le
+func histogramLabels(labels Labels) Labels {
+	for i, l := range labels {
+		if l.Name == "le" {
+			renamed := append(Labels{}, labels...)
+			renamed[i].Name = "exported_le"
+			return renamed
+		}
+	}
+	return labels
+}
+
+// formatLabels converts labels, and any extra ones, into {name="value",...},
+// or nothing if there aren't any
+func formatLabels(labels Labels, extra ...Label) string {
+	if len(labels)+len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range append(append(Labels{}, labels...), extra...) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(metricName(l.Name))
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// labelEscaper escapes label values the way both formats require
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricName replaces anything that isn't allowed in a metric or label name with _
+func metricName(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// formatFloat formats v as briefly as will parse back exactly
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatTime formats t as seconds since the epoch
+func formatTime(t time.Time) string {
+	return formatFloat(float64(t.UnixNano()) / float64(time.Second))
+}
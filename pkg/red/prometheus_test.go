@@ -0,0 +1,87 @@
+package red
+
+// prometheus_test is GoConvey tests of the Prometheus exposition handler
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// scrape gets the body and content type from h, as a scraper would
+func scrape(h http.Handler, accept string) (string, string) {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+	return string(body), rec.Header().Get("Content-Type")
+}
+
+// TestPrometheusHandler confirms both formats have counters, labels and histograms
+func TestPrometheusHandler(t *testing.T) {
+	Convey("Given a red with a labelled series and latencies", t, func() {
+		r := NewRegistry().New("model-uploads")
+		_ = r.Add(REQUESTS, 3)
+		_ = r.Add(ERRORS, 1)
+		upload := r.With("endpoint", "/upload", "method", "POST")
+		_ = upload.Add(REQUESTS, 2)
+		_ = upload.Observe(3 * time.Millisecond)
+		_ = upload.Observe(20 * time.Millisecond)
+		_ = upload.Observe(time.Minute)
+		h := PrometheusHandler(r)
+
+		Convey("The text format has _total counters with labels", func() {
+			body, contentType := scrape(h, "")
+			So(contentType, ShouldEqual, PrometheusContentType)
+			So(body, ShouldContainSubstring, "# TYPE model_uploads_requests_total counter\n")
+			So(body, ShouldContainSubstring, "\nmodel_uploads_requests_total 3\n")
+			So(body, ShouldContainSubstring, "\nmodel_uploads_errors_total 1\n")
+			So(body, ShouldContainSubstring, `model_uploads_requests_total{endpoint="/upload",method="POST"} 2`)
+			So(body, ShouldContainSubstring, "# TYPE model_uploads_duration_seconds_total counter\n")
+			So(body, ShouldContainSubstring, "# TYPE model_uploads_start_time_seconds gauge\n")
+			So(body, ShouldNotContainSubstring, "_created")
+			So(body, ShouldNotContainSubstring, "# EOF")
+		})
+
+		Convey("And a histogram for the series that observed latencies", func() {
+			body, _ := scrape(h, "")
+			So(body, ShouldContainSubstring, "# TYPE model_uploads_latency_seconds histogram\n")
+			So(body, ShouldContainSubstring, `model_uploads_latency_seconds_bucket{endpoint="/upload",method="POST",le="0.005"} 1`)
+			So(body, ShouldContainSubstring, `model_uploads_latency_seconds_bucket{endpoint="/upload",method="POST",le="0.025"} 2`)
+			So(body, ShouldContainSubstring, `model_uploads_latency_seconds_bucket{endpoint="/upload",method="POST",le="10"} 2`)
+			So(body, ShouldContainSubstring, `model_uploads_latency_seconds_bucket{endpoint="/upload",method="POST",le="+Inf"} 3`)
+			So(body, ShouldContainSubstring, `model_uploads_latency_seconds_sum{endpoint="/upload",method="POST"} 60.023`)
+			So(body, ShouldContainSubstring, `model_uploads_latency_seconds_count{endpoint="/upload",method="POST"} 3`)
+		})
+
+		Convey("OpenMetrics is served when asked for", func() {
+			body, contentType := scrape(h, "application/openmetrics-text; version=1.0.0,text/plain;q=0.5")
+			So(contentType, ShouldEqual, OpenMetricsContentType)
+			So(body, ShouldContainSubstring, "# TYPE model_uploads_requests counter\n")
+			So(body, ShouldContainSubstring, "\nmodel_uploads_requests_total 3\n")
+			So(body, ShouldContainSubstring, "\nmodel_uploads_requests_created ")
+			So(body, ShouldEndWith, "# EOF\n")
+		})
+	})
+
+	Convey("Given a series with its own le label, its histogram renames it", t, func() {
+		r := NewRegistry().New("lookups")
+		_ = r.With("le", "x").Observe(3 * time.Millisecond)
+		body, _ := scrape(PrometheusHandler(r), "")
+		So(body, ShouldContainSubstring, `lookups_latency_seconds_bucket{exported_le="x",le="0.005"} 1`)
+		So(body, ShouldContainSubstring, `lookups_latency_seconds_count{exported_le="x"} 1`)
+		So(body, ShouldContainSubstring, `lookups_requests_total{le="x"} 0`)
+		So(body, ShouldNotContainSubstring, `{le="x",le=`)
+	})
+
+	Convey("Given awkward names and label values, they're made legal", t, func() {
+		So(metricName("2xx.rate-limit"), ShouldEqual, "_2xx_rate_limit")
+		So(formatLabels(Labels{{"path", "a\"b\\c\nd"}}), ShouldEqual, `{path="a\"b\\c\nd"}`)
+	})
+}
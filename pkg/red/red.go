@@ -2,24 +2,35 @@ package red
 
 // Red is the minimum set of metrics we commonly use.
 // The public members are for callers, everything
-// after init() is the back-end implementation.
+// after "Private members" is the back-end implementation.
 // This uses channels to avoid locking, w preformance
 // problem we have elsewhere.
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Red is the master struct for tracking REQUESTS, Errors and Duration
+// Red is the master struct for tracking REQUESTS, Errors and Duration.
+// A *Red is also a handle on a worker, which goroutines may share: they
+// should read the copy that Now() returns rather than the fields of the
+// shared handle, which other goroutines' calls update.
 type Red struct {
 	Requests  int64         `json:"requests"`
 	Errors    int64         `json:"errors"`
 	Duration  time.Duration `json:"duration"`
 	StartTime time.Time     `json:"start_time"`
+	Latency   *Latency      `json:"latency,omitempty"` // set by Now(), if Observe() was used
+	Elapsed   time.Duration `json:"elapsed,omitempty"` // wall-clock time, if Duration isn't and Options.Elapsed was set
+	Labels    Labels        `json:"labels,omitempty"`  // set by With()
+	EndTime   time.Time     `json:"end_time"`          // set by Swap(), when the interval ended
+	inst      *instance     // the worker this is a handle on
+	ser       *series       // the series in it, nil for the unlabelled one
+	batch     *batcher      // this handle's unsent adds, if it's batched
 }
 
 // RED is the minimum signature of a Red implementation
@@ -57,25 +68,32 @@ func (field Fields) String() string {
 	}
 }
 
-// Start returns a handle and sets the Start time.
+// Start returns a handle on the default Red and sets the Start time.
 // Calling it repeatedly merely causes it to restart the counts.
 func Start() *Red {
-	// Use "main" so that it will work the very first time it's called
-	main.send(start, NONE, 0)
+	return Default.New(DefaultName).Start()
+}
+
+// Start restarts the counts of the Red that r is a handle on, and
+// returns a fresh handle. Other Reds are not affected.
+func (r *Red) Start() *Red {
+	if r == nil || r.inst == nil {
+		// Use the default so that it will work the very first time it's called
+		return Start()
+	}
 	// The user interface strictly uses this copy, so that code can't actually
-	// touch main concurrently with the worker code.
-	var ui = &Red{
-		0,
-		0,
-		0,
-		time.Now(),
+	// touch the instance concurrently with the worker code.
+	tmp, err := r.call(start, NONE, 0)
+	if err != nil {
+		// it's closed, so there's nothing to restart
+		return r.copy()
 	}
-	return ui
+	return &tmp
 }
 
-// Add sends an add message to a singleton worker
+// Add sends an add message to r's worker
 func (r *Red) Add(f Fields, val int64) error {
-	if r == nil {
+	if r == nil || r.inst == nil {
 		return fmt.Errorf("r is nil, please call Start() first")
 	}
 	switch f {
@@ -87,10 +105,19 @@ func (r *Red) Add(f Fields, val int64) error {
 			// if you want contention from Add, you need to use 1,000,000 microsecond, 1 second
 			//time.Sleep(1000000 * time.Microsecond)
 		}
-		r.send(add, f, val)
-		tmp := r.receive()
-		r.Requests, r.Errors, r.Duration = tmp.Requests, tmp.Errors, tmp.Duration
-		return nil
+		if r.batch != nil {
+			// fire-and-forget, the worker will pick it up
+			return r.batch.add(f, val)
+		}
+		if r.inst.backend == Sharded {
+			// likewise, and without even a batch to fill
+			if r.inst.isClosed() {
+				return ErrClosed
+			}
+			r.target().shards.add(f, val)
+			return nil
+		}
+		return r.update(r.call(add, f, val))
 	default:
 		return fmt.Errorf("usage error, unsupported operand %q for Add(%q, %d)", f.String(), f, val)
 	}
@@ -101,7 +128,7 @@ func (r *Red) BadAdd(f Fields, val int64) error {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if r == nil {
+	if r == nil || r.inst == nil {
 		return fmt.Errorf("r is nil, please call Start() first")
 	}
 	switch f {
@@ -113,10 +140,7 @@ func (r *Red) BadAdd(f Fields, val int64) error {
 			// means we could have got away with using locks.
 			time.Sleep(100 * time.Nanosecond)
 		}
-		r.send(add, f, val)
-		tmp := r.receive()
-		r.Requests, r.Errors, r.Duration = tmp.Requests, tmp.Errors, tmp.Duration
-		return nil
+		return r.update(r.call(add, f, val))
 	default:
 		return fmt.Errorf("usage error, unsupported operand %q for Add(%q, %d)", f.String(), f, val)
 	}
@@ -128,15 +152,12 @@ var Nanosleep = false
 
 // Set sends a set message
 func (r *Red) Set(f Fields, val int64) error {
-	if r == nil {
+	if r == nil || r.inst == nil {
 		return fmt.Errorf("r is nil, please call Start() first")
 	}
 	switch f {
 	case REQUESTS, ERRORS:
-		r.send(set, f, val)
-		tmp := r.receive()
-		r.Requests, r.Errors, r.Duration = tmp.Requests, tmp.Errors, tmp.Duration
-		return nil
+		return r.update(r.call(set, f, val))
 	default:
 		return fmt.Errorf("usage error, unsupported operand %q for Set(%q, %d)", f.String(), f, val)
 	}
@@ -144,27 +165,29 @@ func (r *Red) Set(f Fields, val int64) error {
 
 // GetAll sends a getall message
 func (r *Red) GetAll() error {
-	if r == nil {
+	if r == nil || r.inst == nil {
 		return fmt.Errorf("r is nil, please call Start() first")
 	}
-	r.send(getall, NONE, 0)
-	tmp := r.receive()
-	r.Requests, r.Errors, r.Duration = tmp.Requests, tmp.Errors, tmp.Duration
-	return nil
+	return r.update(r.call(getall, NONE, 0))
 }
 
 // Now fills in the Duration field of a Red. Often used to end a time-period,
 // as in fmt.Printf("%s\n", red.Now().String()).
-// It will compute Red.Duration each time it's called, using time.Since()
+// It will compute Red.Duration each time it's called, using time.Since(),
+// unless the Red measures busy or CPU time instead of wall-clock time.
+// It returns a private copy, which is what goroutines sharing r should read.
+// Once the Red is closed, the copy is of the last values r was given.
 func (r *Red) Now() *Red {
-	if r == nil {
-		// create a temporary one so we don't have to return a non-Red
-		r = &Red{}
+	if r == nil || r.inst == nil {
+		// use the default so we don't have to return a non-Red
+		r = &Red{inst: Default.instance(DefaultName, Options{})}
 	}
-	r.send(now, NONE, 0)
-	tmp := r.receive()
-	r.Requests, r.Errors, r.Duration = tmp.Requests, tmp.Errors, tmp.Duration
-	return r
+	tmp, err := r.call(now, NONE, 0)
+	if err != nil {
+		return r.copy()
+	}
+	_ = r.update(tmp, nil)
+	return &tmp
 }
 
 // String converts r into a string.  If you want it to contain anything,
@@ -173,23 +196,12 @@ func (r *Red) String() string {
 	if r == nil {
 		return "r is nil, please call Start() first"
 	}
+	if r.Latency != nil {
+		return fmt.Sprintf("%d, %d, %fs, %s", r.Requests, r.Errors, r.Duration.Seconds(), r.Latency)
+	}
 	return fmt.Sprintf("%d, %d, %fs", r.Requests, r.Errors, r.Duration.Seconds())
 }
 
-// MarshalJSON converts r into a shortened json. As with String(),
-// call Now() first if you want to know the Duration.
-func (r *Red) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Requests int64         `json:"requests"`
-		Errors   int64         `json:"errors"`
-		Duration time.Duration `json:"duration"`
-	}{
-		r.Requests,
-		r.Errors,
-		r.Duration,
-	})
-}
-
 // Subtract is a convenience function for a caller who subtracts two measurements to get
 // a rate, a common use case.
 func (r *Red) Subtract(v *Red) *Red {
@@ -200,30 +212,90 @@ func (r *Red) Subtract(v *Red) *Red {
 }
 
 // Private members of Red
-// main is the internal Red variable, protected from concurrent access
-var main Red
-var toWorker chan msg
-var fromWorker chan *Red
 var verbose = false
 
-// init creates a back end
-func init() {
+// instance is the back end of one named Red. Its series are
+// protected from concurrent access by only being touched by its worker,
+// or, for the Mutex and Sharded backends, by holding lock.
+type instance struct {
+	name     string
+	root     *series            // the unlabelled series, never changes
+	series   map[string]*series // all of them, by labels.key()
+	toWorker chan msg
+	mu       sync.Mutex // protects the public fields of handles on this instance
+	reg      *Registry  // the registry it's in, until it's closed
+
+	// closed is set when Close() is called, senders counts the calls
+	// to do() that might not have seen it yet
+	closed  int32 // atomic
+	senders int64 // atomic
+
+	seq uint64 // atomic, the number of the last Sample()
+
+	saver *saver // saves checkpoints, if Options.Checkpoint has a path
+
+	backend Backend
+	lock    sync.Mutex // serializes operations, if the backend isn't Channel
+	timing  Timing     // what main.Duration measures
+	elapsed bool       // whether Now() also reports wall-clock time
+
+	// batched handles, and how often the worker drains them
+	batchers []*batcher
+	interval time.Duration
+	ticker   *time.Ticker
+	tick     <-chan time.Time
+}
+
+// newInstance creates a back end and starts its worker. Only the
+// Channel backend sends it operations, the others just its ticks.
+func newInstance(name string, opts Options) *instance {
 	// The channel size is a tuning parameter, and should
 	// be larger than the number of callers so the callers
 	// don't have to wait while the goroutine make the
 	// changes single-threaded. 1 is too low, while
 	// 1000 is only slightly better than 100 in MY benchmark.
 	// YOUR milage will vary.
-	toWorker = make(chan msg, 100)
-	fromWorker = make(chan *Red, 100)
-	go worker()
+	inst := &instance{
+		name:     name,
+		series:   make(map[string]*series),
+		toWorker: make(chan msg, 100),
+		backend:  opts.Backend,
+		timing:   opts.Timing,
+		elapsed:  opts.Elapsed,
+		// the ticker is idle until there are batched handles
+		ticker: time.NewTicker(time.Hour),
+	}
+	inst.root = inst.newSeries(nil, time.Now())
+	inst.ticker.Stop()
+	inst.tick = inst.ticker.C
+	if opts.Checkpoint.Path != "" {
+		inst.saver = newSaver(opts.Checkpoint)
+		inst.restore(time.Now())
+	}
+	go inst.worker()
+	if inst.saver != nil {
+		go inst.saver.periodically(inst)
+	}
+	return inst
 }
 
 // msg is what the UI sends to the worker via a channel
 type msg struct {
-	operation ops    // add, getall, set, etc
-	operand   Fields // request, error and Duration
-	value     int64  // its value
+	operation ops         // add, getall, set, etc
+	operand   Fields      // request, error and Duration
+	value     int64       // its value
+	reply     chan answer // where the worker answers this one message, or nil
+	series    *series     // the series to operate on, nil for the unlabelled one
+	batch     *batcher    // a batched handle, for the batch operation
+	counts    deltas      // several fields at once, for the end operation
+	labels    Labels      // the labels to find or make a series for, or to match
+}
+
+// answer is what the worker replies with
+type answer struct {
+	red Red   // the series operated on
+	all []Red // the series asked for, for nowseries
+	err error // why the operation failed, if it did
 }
 
 // ops is an enum of the operations that the package does
@@ -235,6 +307,15 @@ const (
 	set
 	start
 	now
+	batch
+	flush
+	observe
+	end
+	with
+	nowseries
+	swap
+	swapseries
+	closing
 )
 
 func (op ops) String() string {
@@ -249,88 +330,328 @@ func (op ops) String() string {
 		return "StartTime"
 	case now:
 		return "now"
+	case batch:
+		return "batch"
+	case flush:
+		return "flush"
+	case observe:
+		return "observe"
+	case end:
+		return "end"
+	case with:
+		return "with"
+	case nowseries:
+		return "nowseries"
+	case swap:
+		return "swap"
+	case swapseries:
+		return "swapseries"
+	case closing:
+		return "close"
 	}
 	return "unknown operation"
 }
 
-// send sends a request to the worker from the UI
-func (r *Red) send(operation ops, operand Fields, value int64) {
-	toWorker <- msg{
-		operation,
-		operand,
-		value,
+// drains is true for the operations that report or reset values, which
+// must pick up the batched and sharded adds first
+func (op ops) drains() bool {
+	switch op {
+	case getall, set, start, now, flush, nowseries, swap, swapseries, closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// replies is a pool of reply channels, so each call gets its own
+// answer without having to make a new channel every time
+var replies = sync.Pool{
+	New: func() interface{} {
+		return make(chan answer, 1)
+	},
+}
+
+// call sends a request to the worker from the UI, and waits for the
+// reply to that request and no other
+func (r *Red) call(operation ops, operand Fields, value int64) (Red, error) {
+	a, err := r.ask(msg{
+		operation: operation,
+		operand:   operand,
+		value:     value,
+	})
+	return a.red, err
+}
+
+// ask sends m to the worker on behalf of r, and waits for its answer
+func (r *Red) ask(m msg) (answer, error) {
+	ch := replies.Get().(chan answer)
+	m.reply = ch
+	m.series = r.ser
+	if err := r.inst.do(m); err != nil {
+		replies.Put(ch)
+		return answer{}, err
+	}
+	tmp := <-ch
+	replies.Put(ch)
+	return tmp, tmp.err
+}
+
+// update copies a reply into the public fields of the handle r, unless
+// there was an error getting it. Only the copy is locked, the worker never is.
+func (r *Red) update(tmp Red, err error) error {
+	if err != nil {
+		return err
 	}
+	r.inst.mu.Lock()
+	r.Requests, r.Errors, r.Duration, r.StartTime = tmp.Requests, tmp.Errors, tmp.Duration, tmp.StartTime
+	r.Latency, r.Elapsed, r.Labels = tmp.Latency, tmp.Elapsed, tmp.Labels
+	r.inst.mu.Unlock()
+	return nil
 }
 
-// receive gets stuff sent back from worker to the UI
-func (r *Red) receive() *Red {
-	msg := <-fromWorker
-	return msg
+// copy makes a private copy of the handle r, for when the worker can't
+func (r *Red) copy() *Red {
+	r.inst.mu.Lock()
+	tmp := *r
+	r.inst.mu.Unlock()
+	return &tmp
 }
 
-// reply sends to fromWorker, for worker to use to reply to the UI
+// reply answers the UI on the channel that came with m, if it has one.
 // note that main doesn't get the error, that's specific to the
 // call from the UI
-func (r *Red) reply(s Red) {
+func (inst *instance) reply(m *msg, ser *series, s Red) {
+	if m.reply == nil {
+		// fire-and-forget, no one is waiting
+		return
+	}
 	var tmp = s
-	fromWorker <- &tmp
+	tmp.inst = inst
+	if ser != inst.root {
+		tmp.ser = ser
+	}
+	inst.answer(m, answer{red: tmp})
 }
 
-// Worker serializes the senders, manipulates main.
-func worker() {
-	var tmp Red
+// fail answers the UI with an error instead of a Red. If no one is
+// waiting, or it already has its answer, it logs the error instead.
+func (inst *instance) fail(m *msg, err error) {
+	if m.reply == nil {
+		log.Printf("red %q: %s\n", inst.name, err)
+		return
+	}
+	inst.answer(m, answer{err: err})
+}
 
-	for m := range toWorker {
-		if verbose {
-			log.Printf("worker got %q, %q, %d\n", m.operation.String(), m.operand, m.value)
-		}
-		switch m.operation {
-		case start:
-			// StartTime a time period
-			main = Red{
-				0,
-				0,
-				0,
-				time.Now(),
-			}
+// answer sends a, and forgets the reply channel, which the UI may put
+// back in the pool and reuse as soon as it has a. Nothing more is sent
+// on it, even if the operation then panics.
+func (inst *instance) answer(m *msg, a answer) {
+	m.reply <- a
+	m.reply = nil
+}
+
+// rescue turns a panic while applying m into an error for its sender,
+// so that one bad message can't take down the whole process.
+// It must be deferred, as it calls recover().
+func (inst *instance) rescue(m *msg) {
+	if p := recover(); p != nil {
+		inst.fail(m, fmt.Errorf("worker recovered from a panic in %q: %v", m.operation.String(), p))
+	}
+}
 
-		case add:
-			// add to a field
-			switch m.operand {
-			case REQUESTS:
-				main.Requests += m.value
-			case ERRORS:
-				main.Errors += m.value
-			default:
-				panic(fmt.Errorf("programmer error, unknown operand %q in %#v", m.operand, m))
+// worker serializes the senders, manipulates main.
+func (inst *instance) worker() {
+	for {
+		select {
+		case m, ok := <-inst.toWorker:
+			if !ok {
+				// a backend that isn't Channel was closed
+				inst.ticker.Stop()
+				return
+			}
+			if m.operation == closing {
+				// finish everything queued before replying, and stop
+				inst.quiesce()
+				inst.apply(m)
+				return
 			}
-			main.reply(main)
-
-		case set:
-			// override a field
-			switch m.operand {
-			case REQUESTS:
-				main.Requests = m.value
-			case ERRORS:
-				main.Errors = m.value
-			default:
-				panic(fmt.Errorf("programmer error, unknown operand %q in %#v", m.operand, m))
+			inst.apply(m)
+		case <-inst.tick:
+			// pick up batched adds that haven't been flushed by their senders
+			if inst.backend == Channel {
+				inst.apply(msg{operation: flush})
+			} else {
+				inst.do(msg{operation: flush})
 			}
-			main.reply(main)
+		}
+	}
+}
+
+// apply does one operation to a series, and replies if the sender is waiting.
+// Every reply has either the values or an error, even if the operation panics.
+func (inst *instance) apply(m msg) {
+	defer inst.rescue(&m)
+	var ser = m.series
+	if ser == nil {
+		ser = inst.root
+	}
+	var main = &ser.main
 
-		case getall:
-			main.reply(main)
+	if verbose {
+		log.Printf("worker %q got %q, %q, %d\n", inst.name, m.operation.String(), m.operand, m.value)
+	}
+	// Operations that report or reset values see the batched adds first,
+	// so the values are exact. The others don't pay to walk every series.
+	if m.operation.drains() {
+		inst.drain()
+	}
+	switch m.operation {
+	case start:
+		// StartTime a time period for every series, throwing away anything still batched
+		t := time.Now()
+		for _, s := range inst.series {
+			inst.restart(s, t)
+		}
+		inst.reply(&m, ser, *main)
 
-		case now:
-			// report the values, as of now. Doesn't touch main
-			tmp = main
-			tmp.Duration = time.Since(main.StartTime)
-			main.reply(tmp)
+	case add:
+		// add to a field
+		switch m.operand {
+		case REQUESTS:
+			main.Requests += m.value
+		case ERRORS:
+			main.Errors += m.value
 		default:
-			panic(fmt.Errorf("programmer error, unknown opcode %q in %#v", m.operation.String(), m))
+			inst.fail(&m, fmt.Errorf("programmer error, unknown operand %q in %#v", m.operand, m))
+			return
 		}
-		if verbose {
-			log.Printf("after that operation, main = %#v\n", main)
+		inst.reply(&m, ser, *main)
+
+	case set:
+		// override a field
+		switch m.operand {
+		case REQUESTS:
+			main.Requests = m.value
+		case ERRORS:
+			main.Errors = m.value
+		default:
+			inst.fail(&m, fmt.Errorf("programmer error, unknown operand %q in %#v", m.operand, m))
+			return
+		}
+		inst.reply(&m, ser, *main)
+
+	case getall, flush:
+		// flush has already happened, above
+		inst.reply(&m, ser, *main)
+
+	case batch:
+		// start picking up a new batched handle's adds
+		if interval := m.batch.interval; inst.interval == 0 || interval < inst.interval {
+			inst.interval = interval
+			inst.ticker.Reset(inst.interval)
 		}
+		inst.batchers = append(inst.batchers, m.batch)
+		inst.reply(&m, ser, *main)
+
+	case observe:
+		// record a duration, no one is waiting for this
+		if ser.hist == nil {
+			ser.hist = &histogram{}
+		}
+		ser.hist.record(m.value)
+
+	case end:
+		// a transaction ended, no one is waiting for this
+		m.counts.drainInto(main, inst.timing == BusyTime)
+
+	case with:
+		// find or make the series with these labels
+		found, ok := inst.series[m.labels.key()]
+		if !ok {
+			found = inst.newSeries(m.labels, time.Now())
+		}
+		inst.reply(&m, found, found.main)
+
+	case now:
+		// report the values, as of now. Doesn't touch main
+		inst.reply(&m, ser, inst.now(ser))
+
+	case nowseries, swapseries:
+		// report every series that has the labels asked for, and
+		// maybe restart them, all as of the same instant
+		var all []Red
+		t := time.Now()
+		for _, s := range inst.series {
+			if s.labels.match(m.labels) && (s != inst.root || len(m.labels) == 0) {
+				tmp := inst.snapshot(s, t)
+				tmp.inst = inst
+				if s != inst.root {
+					tmp.ser = s
+				}
+				if m.operation == swapseries {
+					tmp.EndTime = t
+					inst.restart(s, t)
+				}
+				all = append(all, tmp)
+			}
+		}
+		sort.Slice(all, func(i, j int) bool {
+			return all[i].Labels.key() < all[j].Labels.key()
+		})
+		inst.answer(&m, answer{red: inst.now(ser), all: all})
+
+	case closing:
+		// save and report the final values. The caller stops the worker
+		inst.ticker.Stop()
+		inst.saveFinal()
+		inst.reply(&m, ser, inst.now(ser))
+
+	case swap:
+		// report the finished interval and start the next one,
+		// at the same instant, so nothing falls between them
+		t := time.Now()
+		tmp := inst.snapshot(ser, t)
+		tmp.EndTime = t
+		inst.restart(ser, t)
+		inst.reply(&m, ser, tmp)
+
+	default:
+		inst.fail(&m, fmt.Errorf("programmer error, unknown opcode %q in %#v", m.operation.String(), m))
+		return
+	}
+	if verbose {
+		log.Printf("after that operation, %q = %#v\n", inst.name, *main)
+	}
+}
+
+// now reports the values of ser as of now. It doesn't change them.
+func (inst *instance) now(ser *series) Red {
+	return inst.snapshot(ser, time.Now())
+}
+
+// snapshot reports the values of ser as of t, which is about now.
+func (inst *instance) snapshot(ser *series, t time.Time) Red {
+	tmp := ser.main
+	switch inst.timing {
+	case WallClock:
+		tmp.Duration = t.Sub(tmp.StartTime)
+	case CPUTime:
+		tmp.Duration = inst.cpuTime() - ser.cpuAt
+	}
+	if inst.elapsed && inst.timing != WallClock {
+		tmp.Elapsed = t.Sub(tmp.StartTime)
+	}
+	tmp.Latency = ser.hist.latency()
+	return tmp
+}
+
+// restart zeroes ser, starting a new time period at t
+func (inst *instance) restart(ser *series, t time.Time) {
+	ser.main = Red{StartTime: t, Labels: ser.labels}
+	ser.hist = nil
+	atomic.StoreInt64(&ser.restored, 0)
+	if inst.timing == CPUTime {
+		ser.cpuAt = inst.cpuTime()
 	}
 }
@@ -104,6 +104,39 @@ func TestRedUninitializedPath(t *testing.T) {
 	})
 }
 
+// TestRedConcurrentHandles confirms that goroutines sharing a handle each get
+// their own replies, and is meant to be run with go test -race
+func TestRedConcurrentHandles(t *testing.T) {
+	var r = New("concurrent").Start()
+	var wg sync.WaitGroup
+	const goroutines, adds = 50, 100
+
+	Convey("Given a red shared by many goroutines", t, func() {
+		wg.Add(goroutines)
+		for i := 0; i < goroutines; i++ {
+			go func() {
+				defer wg.Done()
+				var last int64
+				for j := 0; j < adds; j++ {
+					_ = r.Add(REQUESTS, 1)
+					now := r.Now()
+					if now.Requests < last {
+						t.Errorf("got someone else's reply, requests went from %d to %d", last, now.Requests)
+					}
+					last = now.Requests
+					_ = now.String()
+					_ = r.GetAll()
+				}
+			}()
+		}
+		wg.Wait()
+
+		Convey("Every add is counted exactly once", func() {
+			So(r.Now().Requests, ShouldEqual, goroutines*adds)
+		})
+	})
+}
+
 // TestRedUnhappyPath confirms we're doing the operations properly
 func TestRedUnhappyPath(t *testing.T) {
 	var r = Start()
@@ -114,16 +147,42 @@ func TestRedUnhappyPath(t *testing.T) {
 			//t.Logf("red_test r.String() %q, should equal logical zero\n", r.String())
 			So(r.String(), ShouldEqual, "0, 0, 0.000000s")
 		})
-		//SkipConvey("Passing bad operations to send should panic worker", func(c C) {
-		//	// the panic happens in worker(), not send,
-		//	// FIXME, in future, see if goConvey can pass it back to here
-		//	c.So(func() { r.send(-1, ERRORS, 1) }, ShouldPanic)
-		//
-		//})
-		//SkipConvey("Passing bad operands to send should panic worker", func(c C) {
-		//	// the panic happens in worker(), not send
-		//	c.So(func() { r.send(set, NONE, 1) }, ShouldPanic)
-		//})
+		Convey("Passing bad operations to the worker should return an error", func() {
+			_, err := r.call(-1, ERRORS, 1)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "programmer error, unknown opcode")
+		})
+		Convey("Passing bad operands to the worker should return an error", func() {
+			_, err := r.call(set, NONE, 1)
+			So(err, ShouldNotBeNil)
+			_, err = r.call(add, DURATION, 1)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("A panic in the worker should be returned, and the worker keep going", func() {
+			// a batch message without a batch makes the worker dereference nil
+			_, err := r.ask(msg{operation: batch})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "recovered from a panic")
+			So(r.Add(REQUESTS, 1), ShouldBeNil)
+			So(r.GetAll(), ShouldBeNil)
+		})
+		Convey("A panic after replying shouldn't send the error to the next user of the channel", func() {
+			ch := make(chan answer, 1)
+			m := msg{operation: getall, reply: ch}
+			var got answer
+			func() {
+				defer r.inst.rescue(&m)
+				r.inst.reply(&m, r.inst.root, Red{Requests: 1})
+				// the caller takes its answer, and the channel is free for reuse
+				got = <-ch
+				panic("after replying")
+			}()
+			So(got.err, ShouldBeNil)
+			So(len(ch), ShouldEqual, 0)
+		})
+		Convey("Adding an unsupported field should return an error", func() {
+			So(r.Add(DURATION, 1), ShouldNotBeNil)
+		})
 	})
 }
 
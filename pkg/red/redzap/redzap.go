@@ -0,0 +1,55 @@
+// Package redzap logs a Red with zap as typed fields, the same ones
+// Red.LogValue gives slog. It's a package of its own, so that programs
+// using pkg/red without zap don't build it, and module graph pruning
+// keeps zap out of their go.sum.
+package redzap
+
+import (
+	"github.com/davecb/RED/pkg/red"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Red returns a field that logs r as an object, as in
+//
+//	logger.Info("upload metrics", zap.String("set", set), redzap.Red("red", r.Now()))
+//
+// or, with a SugaredLogger,
+//
+//	s.logger.Infow("upload metrics", "set", set, redzap.Red("red", r.Now()))
+func Red(key string, r *red.Red) zap.Field {
+	return zap.Object(key, Marshaler{r})
+}
+
+// Marshaler is a zapcore.ObjectMarshaler for a Red
+type Marshaler struct {
+	R *red.Red
+}
+
+// MarshalLogObject adds requests, errors, duration_seconds, start_time,
+// the rates and any labels to enc
+func (m Marshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	r := m.R
+	if r == nil {
+		enc.AddString("error", "r is nil, please call Start() first")
+		return nil
+	}
+	requests, errors := r.Rates()
+	enc.AddInt64("requests", r.Requests)
+	enc.AddInt64("errors", r.Errors)
+	enc.AddFloat64("duration_seconds", r.Duration.Seconds())
+	if !r.StartTime.IsZero() {
+		enc.AddTime("start_time", r.StartTime)
+	}
+	enc.AddFloat64("requests_per_second", requests)
+	enc.AddFloat64("errors_per_second", errors)
+	if len(r.Labels) > 0 {
+		return enc.AddObject("labels", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			for _, l := range r.Labels {
+				enc.AddString(l.Name, l.Value)
+			}
+			return nil
+		}))
+	}
+	return nil
+}
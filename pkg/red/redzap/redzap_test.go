@@ -0,0 +1,35 @@
+package redzap
+
+// redzap_test is tests of the zap marshaler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecb/RED/pkg/red"
+	"go.uber.org/zap/zapcore"
+)
+
+// TestMarshalLogObject confirms a Red is encoded as typed fields
+func TestMarshalLogObject(t *testing.T) {
+	start := time.Date(2021, 12, 24, 17, 7, 39, 0, time.UTC)
+	r := &red.Red{Requests: 45225, Duration: 1563562311863, StartTime: start,
+		Labels: red.Labels{{Name: "endpoint", Value: "/upload"}}}
+	enc := zapcore.NewMapObjectEncoder()
+
+	if err := (Marshaler{r}).MarshalLogObject(enc); err != nil {
+		t.Fatalf("MarshalLogObject failed, %v", err)
+	}
+	if got := enc.Fields["requests"]; got != int64(45225) {
+		t.Errorf("requests was %v, expected 45225", got)
+	}
+	if got := enc.Fields["duration_seconds"]; got != 1563.562311863 {
+		t.Errorf("duration_seconds was %v, expected 1563.562311863", got)
+	}
+	if got := enc.Fields["start_time"]; got != start {
+		t.Errorf("start_time was %v, expected %v", got, start)
+	}
+	if got := enc.Fields["labels"].(map[string]any)["endpoint"]; got != "/upload" {
+		t.Errorf("labels.endpoint was %v, expected /upload", got)
+	}
+}
@@ -0,0 +1,111 @@
+package red
+
+// registry keeps track of named Reds, so a program can have
+// several independent ones, each with its own worker.
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultName is the name of the Red used by the package-level Start()
+const DefaultName = "default"
+
+// Default is the registry used by the package-level New() and Start()
+var Default = NewRegistry()
+
+// Registry is a set of named Reds. Each has its own counters and its
+// own worker, so starting one does not reset the others.
+type Registry struct {
+	mu   sync.Mutex // protects instances, not the Reds themselves
+	reds map[string]*instance
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{reds: make(map[string]*instance)}
+}
+
+// New returns a handle on the Red called name in the default registry,
+// creating and starting it if it doesn't exist yet.
+func New(name string) *Red {
+	return Default.New(name)
+}
+
+// NewWithOptions is New, for a Red that isn't built the default way
+func NewWithOptions(name string, opts Options) *Red {
+	return Default.NewWithOptions(name, opts)
+}
+
+// New returns a handle on the Red called name, creating and starting
+// it if it doesn't exist yet. Call Start() on the handle to restart it.
+func (reg *Registry) New(name string) *Red {
+	return reg.NewWithOptions(name, Options{})
+}
+
+// NewWithOptions is New, for a Red that isn't built the default way.
+// The options only apply if it doesn't exist yet.
+func (reg *Registry) NewWithOptions(name string, opts Options) *Red {
+	r := &Red{inst: reg.instance(name, opts)}
+	_ = r.GetAll()
+	return r
+}
+
+// Name returns the name of the Red that r is a handle on, or "" if r is nil
+func (r *Red) Name() string {
+	if r == nil || r.inst == nil {
+		return ""
+	}
+	return r.inst.name
+}
+
+// Get returns a handle on the Red called name, or nil if there isn't one
+func (reg *Registry) Get(name string) *Red {
+	reg.mu.Lock()
+	inst, ok := reg.reds[name]
+	reg.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	r := &Red{inst: inst}
+	_ = r.GetAll()
+	return r
+}
+
+// Names returns the names of the Reds in the registry, sorted
+func (reg *Registry) Names() []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	names := make([]string, 0, len(reg.reds))
+	for name := range reg.reds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// instance finds or creates the back end for name
+func (reg *Registry) instance(name string, opts Options) *instance {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	inst, ok := reg.reds[name]
+	if !ok {
+		inst = newInstance(name, opts)
+		inst.reg = reg
+		reg.reds[name] = inst
+	}
+	return inst
+}
+
+// remove takes a closed instance out of the registry, so that
+// New() with its name makes a fresh one
+func (reg *Registry) remove(inst *instance) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.reds[inst.name] == inst {
+		delete(reg.reds, inst.name)
+	}
+}
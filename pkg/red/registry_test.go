@@ -0,0 +1,47 @@
+package red
+
+// registry_test is GoConvey tests of independent, named Reds
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+// TestRegistryIndependence confirms that named Reds don't share counts
+func TestRegistryIndependence(t *testing.T) {
+	var reg = NewRegistry()
+	var uploads = reg.New("uploads").Start()
+	var downloads = reg.New("downloads").Start()
+
+	Convey("Given two named reds in a registry", t, func() {
+
+		Convey("Adding to one doesn't change the other", func() {
+			_ = uploads.Add(REQUESTS, 3)
+			_ = downloads.Add(REQUESTS, 1)
+			_ = uploads.GetAll()
+			_ = downloads.GetAll()
+			So(uploads.Requests, ShouldEqual, 3)
+			So(downloads.Requests, ShouldEqual, 1)
+		})
+
+		Convey("Starting one doesn't wipe the other", func() {
+			_ = uploads.Add(ERRORS, 2)
+			downloads = downloads.Start()
+			_ = uploads.GetAll()
+			So(uploads.Requests, ShouldEqual, 3)
+			So(uploads.Errors, ShouldEqual, 2)
+			So(downloads.Requests, ShouldEqual, 0)
+		})
+
+		Convey("New with an existing name returns a handle on the same red", func() {
+			again := reg.New("uploads")
+			So(again.Requests, ShouldEqual, 3)
+			So(reg.Get("uploads").Errors, ShouldEqual, 2)
+			So(reg.Get("health-checks"), ShouldBeNil)
+		})
+
+		Convey("Names lists them in order", func() {
+			So(reg.Names(), ShouldResemble, []string{"downloads", "uploads"})
+		})
+	})
+}
@@ -0,0 +1,193 @@
+package red
+
+// series are the labelled parts of a Red, such as the requests to
+// one endpoint, or with one method. Each is counted separately.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Label is one name and value that distinguishes a series
+type Label struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Labels are the labels of a series, sorted by name
+type Labels []Label
+
+// badKey is the name given to a value passed to With() without one, as slog does
+const badKey = "!BADKEY"
+
+// makeLabels turns name, value pairs into sorted Labels, with the last
+// value of a repeated name winning
+func makeLabels(kv []string) Labels {
+	var labels Labels
+
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 == len(kv) {
+			labels = append(labels, Label{badKey, kv[i]})
+			break
+		}
+		labels = append(labels, Label{kv[i], kv[i+1]})
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	// keep the last of each name
+	var out Labels
+	for i, l := range labels {
+		if i+1 < len(labels) && labels[i+1].Name == l.Name {
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// merge returns l with the labels in more added or replaced
+func (l Labels) merge(more Labels) Labels {
+	var kv []string
+	for _, x := range l {
+		kv = append(kv, x.Name, x.Value)
+	}
+	for _, x := range more {
+		kv = append(kv, x.Name, x.Value)
+	}
+	return makeLabels(kv)
+}
+
+// Get returns the value of the label called name, or "" if there isn't one
+func (l Labels) Get(name string) string {
+	for _, x := range l {
+		if x.Name == name {
+			return x.Value
+		}
+	}
+	return ""
+}
+
+// String converts l into the form {endpoint="/upload",method="POST"}
+func (l Labels) String() string {
+	var b strings.Builder
+
+	b.WriteByte('{')
+	for i, x := range l {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", x.Name, x.Value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// key is a string that's unique to a set of labels, for a map
+func (l Labels) key() string {
+	if len(l) == 0 {
+		return ""
+	}
+	return l.String()
+}
+
+// match reports whether l has every label in want
+func (l Labels) match(want Labels) bool {
+	for _, w := range want {
+		found := false
+		for _, x := range l {
+			if x == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// With returns a handle on the series of r that has r's labels plus the
+// name, value pairs in kv, as in
+//
+//	r.With("endpoint", "/upload", "method", "POST").Add(REQUESTS, 1)
+//
+// Each set of labels is a separate series, with its own counts. A value
+// without a name is given the name "!BADKEY", so the mistake shows.
+func (r *Red) With(kv ...string) *Red {
+	if r == nil || r.inst == nil {
+		return nil
+	}
+	a, err := r.ask(msg{operation: with, labels: r.target().labels.merge(makeLabels(kv))})
+	if err != nil {
+		// it's closed, so any handle will just return the error
+		return &Red{inst: r.inst}
+	}
+	return &a.red
+}
+
+// NowSeries is Now() for each series of the Red that r is a handle on.
+// If name, value pairs are given, it returns only the series that have
+// all of those labels, otherwise it returns all of them, including the
+// unlabelled one. They're sorted by their labels. It returns none
+// once the Red is closed.
+func (r *Red) NowSeries(kv ...string) []*Red {
+	if r == nil || r.inst == nil {
+		return nil
+	}
+	a, err := r.ask(msg{operation: nowseries, labels: makeLabels(kv)})
+	if err != nil {
+		return nil
+	}
+	reds := make([]*Red, len(a.all))
+	for i := range a.all {
+		reds[i] = &a.all[i]
+	}
+	return reds
+}
+
+// series is one labelled set of counts. The worker, or whoever holds
+// the backend's lock, owns everything but shards, which are atomic.
+type series struct {
+	labels Labels
+	main   Red
+	hist   *histogram    // what Observe() was passed, if it was called
+	shards shards        // the Sharded backend's counters
+	cpuAt  time.Duration // the process' CPU time at the start, for CPUTime
+
+	// restored is when the checkpoint its counts were restored from was
+	// saved, in Unix nanoseconds, or zero. A restart clears it, as the
+	// counts are then its own.
+	restored int64 // atomic
+}
+
+// restoredAt returns when s's counts were restored, or the zero time
+func (s *series) restoredAt() time.Time {
+	if n := atomic.LoadInt64(&s.restored); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// newSeries makes a series and adds it to inst
+func (inst *instance) newSeries(labels Labels, startTime time.Time) *series {
+	s := &series{labels: labels}
+	inst.restart(s, startTime)
+	if inst.backend == Sharded {
+		s.shards = newShards()
+	}
+	inst.series[labels.key()] = s
+	return s
+}
+
+// target returns the series r is a handle on
+func (r *Red) target() *series {
+	if r.ser != nil {
+		return r.ser
+	}
+	return r.inst.root
+}
@@ -0,0 +1,89 @@
+package red
+
+// series_test is GoConvey tests of labelled series
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// TestLabels confirms labels are sorted, deduplicated and matched
+func TestLabels(t *testing.T) {
+	Convey("Given labels in any order", t, func() {
+		l := makeLabels([]string{"method", "POST", "endpoint", "/upload", "method", "GET"})
+
+		Convey("They're sorted by name, and the last value wins", func() {
+			So(l.String(), ShouldEqual, `{endpoint="/upload",method="GET"}`)
+			So(l.Get("method"), ShouldEqual, "GET")
+		})
+
+		Convey("They match any subset of themselves", func() {
+			So(l.match(makeLabels([]string{"method", "GET"})), ShouldBeTrue)
+			So(l.match(nil), ShouldBeTrue)
+			So(l.match(makeLabels([]string{"method", "POST"})), ShouldBeFalse)
+		})
+
+		Convey("A value without a name is a BADKEY", func() {
+			So(makeLabels([]string{"status"}).String(), ShouldEqual, `{!BADKEY="status"}`)
+		})
+	})
+}
+
+// TestWith confirms each set of labels is counted separately
+func TestWith(t *testing.T) {
+	for _, backend := range []Backend{Channel, Mutex, Sharded} {
+		Convey(fmt.Sprintf("Given a %s red with labelled series", backend), t, func() {
+			r := NewRegistry().NewWithOptions("series", Options{Backend: backend})
+			upload := r.With("endpoint", "/upload", "method", "POST")
+			_ = upload.Add(REQUESTS, 3)
+			_ = upload.Add(ERRORS, 1)
+			_ = r.With("endpoint", "/upload", "method", "GET").Add(REQUESTS, 2)
+			_ = r.With("endpoint", "/health").Add(REQUESTS, 1)
+			_ = r.Add(REQUESTS, 10)
+
+			Convey("Each series has its own counts and labels", func() {
+				now := r.With("method", "POST", "endpoint", "/upload").Now()
+				So(now.Requests, ShouldEqual, 3)
+				So(now.Errors, ShouldEqual, 1)
+				So(now.Labels.Get("endpoint"), ShouldEqual, "/upload")
+				So(r.Now().Requests, ShouldEqual, 10)
+			})
+
+			Convey("With adds to the labels of a labelled handle", func() {
+				_ = upload.With("status", "5xx").Add(ERRORS, 1)
+				So(r.With("endpoint", "/upload", "method", "POST", "status", "5xx").Now().Errors, ShouldEqual, 1)
+			})
+
+			Convey("NowSeries returns them all, or those that match", func() {
+				all := r.NowSeries()
+				So(len(all), ShouldEqual, 4)
+				So(all[0].Labels, ShouldBeEmpty)
+				So(all[0].Requests, ShouldEqual, 10)
+
+				uploads := r.NowSeries("endpoint", "/upload")
+				So(len(uploads), ShouldEqual, 2)
+				So(uploads[0].Labels.Get("method"), ShouldEqual, "GET")
+				So(uploads[1].Requests, ShouldEqual, 3)
+				So(uploads[1].Duration, ShouldBeGreaterThan, 0)
+			})
+
+			Convey("Batched and timed handles keep their labels", func() {
+				b, _ := r.With("endpoint", "/batch").Batched(10, time.Hour)
+				_ = b.Add(REQUESTS, 4)
+				_ = b.Begin().End(nil)
+				_ = b.Observe(time.Millisecond)
+				now := r.With("endpoint", "/batch").Now()
+				So(now.Requests, ShouldEqual, 5)
+				So(now.Latency.Count, ShouldEqual, 1)
+			})
+
+			Convey("Start resets every series", func() {
+				r.Start()
+				So(upload.Now().Requests, ShouldEqual, 0)
+				So(len(r.NowSeries()), ShouldEqual, 4)
+			})
+		})
+	}
+}
@@ -0,0 +1,503 @@
+package red
+
+// sql counts a database's requests, errors and latencies, as seen from
+// its database/sql driver, so slowdowns can be told apart from ours.
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// SQLOptions are the choices for a wrapped driver. The zero value
+// counts neither driver.ErrSkip nor sql.ErrNoRows as errors.
+type SQLOptions struct {
+	ErrSkipIsError bool // count a driver's ErrSkip as a failed request
+	NoRowsIsError  bool // count a query that returns no rows as a failed request
+}
+
+// The operations a wrapped driver counts, each in the series with the
+// label op set to its name
+const (
+	SQLConnect  = "connect"
+	SQLPrepare  = "prepare"
+	SQLQuery    = "query"
+	SQLExec     = "exec"
+	SQLBegin    = "begin"
+	SQLCommit   = "commit"
+	SQLRollback = "rollback"
+)
+
+// WrapDriver returns a driver that counts d's operations in r, for use
+// with sql.Register, as in
+//
+//	sql.Register("red-postgres", red.WrapDriver(&pq.Driver{}, r, red.SQLOptions{}))
+//	db, err := sql.Open("red-postgres", dsn)
+//
+// A query is counted when its rows are closed, so its latency includes
+// reading them. Connections are counted when the driver opens them.
+// Taking one from the pool, and waiting for one, happen in database/sql,
+// which the driver doesn't see, so they aren't counted: use the DB's
+// Stats().WaitCount and WaitDuration for those.
+//
+// If ErrSkipIsError isn't set, a driver's ErrSkip isn't counted at all,
+// as database/sql retries the operation with a prepared statement, which
+// is. Where the driver can't execute or query without preparing, the
+// wrapper returns ErrSkip itself, and that's never counted.
+func WrapDriver(d driver.Driver, r *Red, opts SQLOptions) driver.Driver {
+	return &sqlDriver{Driver: d, stats: &sqlStats{r: r, opts: opts}}
+}
+
+// WrapConnector is WrapDriver for a connector, for use with sql.OpenDB
+func WrapConnector(c driver.Connector, r *Red, opts SQLOptions) driver.Connector {
+	d := &sqlDriver{Driver: c.Driver(), stats: &sqlStats{r: r, opts: opts}}
+	return &sqlConnector{connector: c, driver: d}
+}
+
+// sqlStats records operations in the series of r for each op
+type sqlStats struct {
+	r       *Red
+	opts    SQLOptions
+	handles sync.Map // from op to the series' handle
+}
+
+// record counts one operation that began at begun and returned err
+func (s *sqlStats) record(op string, begun time.Time, err error) {
+	if errors.Is(err, driver.ErrSkip) && !s.opts.ErrSkipIsError {
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) && !s.opts.NoRowsIsError {
+		err = nil
+	}
+	series := s.series(op)
+	_ = series.Observe(time.Since(begun))
+	_ = Timer{r: series, begun: begun}.End(err)
+}
+
+// series returns the handle for op, creating it if need be
+func (s *sqlStats) series(op string) *Red {
+	if h, ok := s.handles.Load(op); ok {
+		return h.(*Red)
+	}
+	h, _ := s.handles.LoadOrStore(op, s.r.With("op", op))
+	return h.(*Red)
+}
+
+// sqlDriver is a driver.Driver, and a driver.DriverContext so that
+// sql.Open counts connections through sqlConnector
+type sqlDriver struct {
+	driver.Driver
+	stats *sqlStats
+}
+
+// Open opens and counts a connection
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	begun := time.Now()
+	conn, err := d.Driver.Open(name)
+	d.stats.record(SQLConnect, begun, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{conn: conn, stats: d.stats}, nil
+}
+
+// OpenConnector returns a connector for name
+func (d *sqlDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.Driver.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlConnector{connector: c, driver: d}, nil
+	}
+	return &sqlConnector{name: name, driver: d}, nil
+}
+
+// sqlConnector is a driver.Connector, for either a driver's own
+// connector or a driver that only has Open
+type sqlConnector struct {
+	connector driver.Connector // or nil, if the driver only has Open
+	name      string
+	driver    *sqlDriver
+}
+
+// Connect opens and counts a connection
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.connector == nil {
+		return c.driver.Open(c.name)
+	}
+	begun := time.Now()
+	conn, err := c.connector.Connect(ctx)
+	c.driver.stats.record(SQLConnect, begun, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{conn: conn, stats: c.driver.stats}, nil
+}
+
+// Driver returns the wrapped driver
+func (c *sqlConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// sqlConn is a driver.Conn with all the optional interfaces. Where the
+// driver doesn't have one, it does what database/sql would without it.
+type sqlConn struct {
+	conn  driver.Conn
+	stats *sqlStats
+}
+
+// Prepare prepares and counts a statement
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares and counts a statement
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	begun := time.Now()
+	var stmt driver.Stmt
+	var err error
+	if cp, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = cp.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	c.stats.record(SQLPrepare, begun, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{stmt: stmt, conn: c.conn, stats: c.stats}, nil
+}
+
+// Close closes the connection
+func (c *sqlConn) Close() error {
+	return c.conn.Close()
+}
+
+// Begin begins and counts a transaction
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx begins and counts a transaction
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	begun := time.Now()
+	var tx driver.Tx
+	var err error
+	switch cb, ok := c.conn.(driver.ConnBeginTx); {
+	case ok:
+		tx, err = cb.BeginTx(ctx, opts)
+	case opts.Isolation != driver.IsolationLevel(sql.LevelDefault), opts.ReadOnly:
+		err = errors.New("sql: driver does not support non-default isolation levels or read-only transactions")
+	default:
+		tx, err = c.conn.Begin() // deprecated, but all the driver has
+	}
+	c.stats.record(SQLBegin, begun, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{tx: tx, stats: c.stats}, nil
+}
+
+// ExecContext executes and counts a statement, or returns ErrSkip if
+// the driver can only execute prepared statements
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		// database/sql prepares it instead, which is counted
+		return nil, driver.ErrSkip
+	}
+	begun := time.Now()
+	result, err := ec.ExecContext(ctx, query, args)
+	c.stats.record(SQLExec, begun, err)
+	return result, err
+}
+
+// QueryContext runs a query, which is counted when its rows are
+// closed, or returns ErrSkip if the driver can only run prepared statements
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		// database/sql prepares it instead, which is counted
+		return nil, driver.ErrSkip
+	}
+	begun := time.Now()
+	rows, err := qc.QueryContext(ctx, query, args)
+	if err != nil {
+		c.stats.record(SQLQuery, begun, err)
+		return nil, err
+	}
+	return &sqlRows{rows: rows, stats: c.stats, begun: begun}, nil
+}
+
+// Ping checks the connection, if the driver can
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession resets the connection, if the driver can
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if rs, ok := c.conn.(driver.SessionResetter); ok {
+		return rs.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid reports whether the connection can be reused, if the driver knows
+func (c *sqlConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue converts an argument, or returns ErrSkip so
+// database/sql will, if the driver doesn't
+func (c *sqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// sqlStmt is a driver.Stmt with the context, NamedValueChecker and
+// ColumnConverter interfaces
+type sqlStmt struct {
+	stmt  driver.Stmt
+	conn  driver.Conn // what prepared it, whose NamedValueChecker applies if stmt has none
+	stats *sqlStats
+}
+
+// Close closes the statement
+func (s *sqlStmt) Close() error {
+	return s.stmt.Close()
+}
+
+// NumInput returns the number of placeholders, or -1 if the driver doesn't know
+func (s *sqlStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+// Exec executes and counts the statement
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+// ExecContext executes and counts the statement
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	begun := time.Now()
+	var result driver.Result
+	var err error
+	if se, ok := s.stmt.(driver.StmtExecContext); ok {
+		result, err = se.ExecContext(ctx, args)
+	} else if values, verr := unnamed(args); verr != nil {
+		err = verr
+	} else {
+		result, err = s.stmt.Exec(values) // deprecated, but all the driver has
+	}
+	s.stats.record(SQLExec, begun, err)
+	return result, err
+}
+
+// Query runs the statement, which is counted when its rows are closed
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+// QueryContext runs the statement, which is counted when its rows are closed
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	begun := time.Now()
+	var rows driver.Rows
+	var err error
+	if sq, ok := s.stmt.(driver.StmtQueryContext); ok {
+		rows, err = sq.QueryContext(ctx, args)
+	} else if values, verr := unnamed(args); verr != nil {
+		err = verr
+	} else {
+		rows, err = s.stmt.Query(values) // deprecated, but all the driver has
+	}
+	if err != nil {
+		s.stats.record(SQLQuery, begun, err)
+		return nil, err
+	}
+	return &sqlRows{rows: rows, stats: s.stats, begun: begun}, nil
+}
+
+// CheckNamedValue converts an argument the way the statement, or else
+// its connection, does, or returns ErrSkip so database/sql will, if
+// neither does
+func (s *sqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	if nc, ok := s.conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// ColumnConverter returns the driver's converter for the argument at
+// idx, or the default one database/sql would use without it
+func (s *sqlStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if cc, ok := s.stmt.(driver.ColumnConverter); ok {
+		return cc.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+// named converts values to the positional NamedValues database/sql passes
+func named(values []driver.Value) []driver.NamedValue {
+	args := make([]driver.NamedValue, len(values))
+	for i, v := range values {
+		args[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return args
+}
+
+// unnamed converts args for a driver that doesn't understand names
+func unnamed(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+// sqlTx counts a transaction's commit or rollback
+type sqlTx struct {
+	tx    driver.Tx
+	stats *sqlStats
+}
+
+// Commit commits and counts the transaction
+func (t *sqlTx) Commit() error {
+	begun := time.Now()
+	err := t.tx.Commit()
+	t.stats.record(SQLCommit, begun, err)
+	return err
+}
+
+// Rollback rolls back and counts the transaction
+func (t *sqlTx) Rollback() error {
+	begun := time.Now()
+	err := t.tx.Rollback()
+	t.stats.record(SQLRollback, begun, err)
+	return err
+}
+
+// sqlRows counts its query when it's closed, as a failure if reading
+// it failed, or, if NoRowsIsError is set, if it had no rows. It has the
+// optional RowsColumnType and RowsNextResultSet interfaces, which
+// answer what database/sql assumes without them if the driver's rows
+// don't have them.
+type sqlRows struct {
+	rows  driver.Rows
+	stats *sqlStats
+	begun time.Time
+	read  int   // how many rows were read
+	err   error // the first error reading them
+	done  bool
+}
+
+// Columns returns the names of the columns
+func (r *sqlRows) Columns() []string {
+	return r.rows.Columns()
+}
+
+// Next reads the next row into dest
+func (r *sqlRows) Next(dest []driver.Value) error {
+	err := r.rows.Next(dest)
+	switch {
+	case err == nil:
+		r.read++
+	case err == io.EOF:
+		if r.read == 0 && r.err == nil {
+			r.err = sql.ErrNoRows
+		}
+	case r.err == nil || r.err == sql.ErrNoRows:
+		r.err = err
+	}
+	return err
+}
+
+// Close closes the rows, and counts the query
+func (r *sqlRows) Close() error {
+	err := r.rows.Close()
+	if !r.done {
+		r.done = true
+		failure := r.err
+		if failure == nil {
+			failure = err
+		}
+		r.stats.record(SQLQuery, r.begun, failure)
+	}
+	return err
+}
+
+// ColumnTypeScanType returns the type to scan column index into
+func (r *sqlRows) ColumnTypeScanType(index int) reflect.Type {
+	if ct, ok := r.rows.(driver.RowsColumnTypeScanType); ok {
+		return ct.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeDatabaseTypeName returns the database's name for the type of column index
+func (r *sqlRows) ColumnTypeDatabaseTypeName(index int) string {
+	if ct, ok := r.rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return ct.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength returns the length of column index, if it has one
+func (r *sqlRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if ct, ok := r.rows.(driver.RowsColumnTypeLength); ok {
+		return ct.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable reports whether column index may be null, if the driver knows
+func (r *sqlRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if ct, ok := r.rows.(driver.RowsColumnTypeNullable); ok {
+		return ct.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale returns the precision and scale of a decimal column index
+func (r *sqlRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if ct, ok := r.rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ct.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+// HasNextResultSet reports whether there's another result set after this one
+func (r *sqlRows) HasNextResultSet() bool {
+	if rs, ok := r.rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet moves on to the next result set, which is part of the
+// same query
+func (r *sqlRows) NextResultSet() error {
+	if rs, ok := r.rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
@@ -0,0 +1,259 @@
+package red
+
+// sql_test is GoConvey tests of the database/sql wrapper, against a fake driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeDriver is an in-memory driver. "rows n" returns n rows, "rows n m"
+// returns a result set of n rows then one of m, and any statement
+// starting with "fail" fails. Its connections only run prepared
+// statements, unless fast is set, when they run any but "skip".
+type fakeDriver struct {
+	fast bool
+}
+
+// fakeConnector connects to a fakeDriver
+type fakeConnector struct {
+	d *fakeDriver
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open("") }
+func (c fakeConnector) Driver() driver.Driver                        { return c.d }
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	if name == "fail" {
+		return nil, errors.New("fake: can't connect")
+	}
+	if d.fast {
+		return fastConn{}, nil
+	}
+	return fakeConn{}, nil
+}
+
+// fakeConn only has the required methods
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if query == "fail to prepare" {
+		return nil, errors.New("fake: syntax error")
+	}
+	return fakeStmt(query), nil
+}
+func (fakeConn) Close() error              { return nil }
+func (fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+// fastConn runs statements without preparing them
+type fastConn struct {
+	fakeConn
+}
+
+func (fastConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if query == "skip" {
+		return nil, driver.ErrSkip
+	}
+	return fakeStmt(query).Exec(nil)
+}
+func (fastConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	return fakeStmt(query).Query(nil)
+}
+
+// fakeStmt is a query
+type fakeStmt string
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if strings.HasPrefix(string(s), "fail") {
+		return nil, errors.New("fake: exec failed")
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	if strings.HasPrefix(string(s), "fail") {
+		return nil, errors.New("fake: query failed")
+	}
+	rows := &fakeRows{}
+	for _, field := range strings.Fields(strings.TrimPrefix(string(s), "rows")) {
+		n, _ := strconv.Atoi(field)
+		rows.sets = append(rows.sets, n)
+	}
+	if len(rows.sets) == 0 {
+		rows.sets = []int{0}
+	}
+	return rows, nil
+}
+
+// fakeRows counts up to the first of sets, then the next, and so on
+type fakeRows struct {
+	i    int
+	sets []int
+}
+
+func (r *fakeRows) Columns() []string { return []string{"i"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i == r.sets[0] {
+		return io.EOF
+	}
+	r.i++
+	dest[0] = int64(r.i)
+	return nil
+}
+func (r *fakeRows) ColumnTypeDatabaseTypeName(int) string { return "INTEGER" }
+func (r *fakeRows) HasNextResultSet() bool                { return len(r.sets) > 1 }
+func (r *fakeRows) NextResultSet() error {
+	if len(r.sets) == 1 {
+		return io.EOF
+	}
+	r.i, r.sets = 0, r.sets[1:]
+	return nil
+}
+
+// fakeTx does nothing
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+// TestSQL confirms each kind of operation is counted in its own series
+func TestSQL(t *testing.T) {
+	Convey("Given a database opened through the wrapper", t, func() {
+		r := NewRegistry().NewWithOptions("db", Options{Timing: BusyTime})
+		db := sql.OpenDB(WrapConnector(fakeConnector{&fakeDriver{}}, r, SQLOptions{}))
+		defer db.Close()
+		op := func(name string) *Red {
+			reds := r.NowSeries("op", name)
+			if len(reds) != 1 {
+				return &Red{}
+			}
+			return reds[0]
+		}
+
+		Convey("Execs and queries are counted, with their latencies", func() {
+			_, err := db.Exec("insert")
+			So(err, ShouldBeNil)
+			var n int
+			rows, err := db.Query("rows 3")
+			So(err, ShouldBeNil)
+			for rows.Next() {
+				So(rows.Scan(&n), ShouldBeNil)
+			}
+			So(n, ShouldEqual, 3)
+			So(op(SQLExec).Requests, ShouldEqual, 1)
+			So(op(SQLQuery).Requests, ShouldEqual, 1)
+			So(op(SQLQuery).Errors, ShouldEqual, 0)
+			So(op(SQLQuery).Latency.Count, ShouldEqual, 1)
+			So(op(SQLPrepare).Requests, ShouldEqual, 2)
+			So(op(SQLConnect).Requests, ShouldEqual, 1)
+		})
+
+		Convey("Failures are errors", func() {
+			_, err := db.Exec("fail")
+			So(err, ShouldNotBeNil)
+			_, err = db.Query("fail")
+			So(err, ShouldNotBeNil)
+			_, err = db.Prepare("fail to prepare")
+			So(err, ShouldNotBeNil)
+			So(op(SQLExec).Errors, ShouldEqual, 1)
+			So(op(SQLQuery).Errors, ShouldEqual, 1)
+			So(op(SQLPrepare).Errors, ShouldEqual, 1)
+		})
+
+		Convey("Transactions are counted when begun, committed and rolled back", func() {
+			tx, err := db.Begin()
+			So(err, ShouldBeNil)
+			So(tx.Commit(), ShouldBeNil)
+			tx, err = db.Begin()
+			So(err, ShouldBeNil)
+			So(tx.Rollback(), ShouldBeNil)
+			So(op(SQLBegin).Requests, ShouldEqual, 2)
+			So(op(SQLCommit).Requests, ShouldEqual, 1)
+			So(op(SQLRollback).Requests, ShouldEqual, 1)
+		})
+
+		Convey("No rows aren't errors by default", func() {
+			var n int
+			So(db.QueryRow("rows 0").Scan(&n), ShouldEqual, sql.ErrNoRows)
+			So(op(SQLQuery).Requests, ShouldEqual, 1)
+			So(op(SQLQuery).Errors, ShouldEqual, 0)
+		})
+
+		Convey("No rows are errors if configured to be, and the wrapper's own ErrSkip never is", func() {
+			r2 := NewRegistry().New("db")
+			db2 := sql.OpenDB(WrapConnector(fakeConnector{&fakeDriver{}}, r2,
+				SQLOptions{ErrSkipIsError: true, NoRowsIsError: true}))
+			defer db2.Close()
+			var n int
+			So(db2.QueryRow("rows 0").Scan(&n), ShouldEqual, sql.ErrNoRows)
+			_, err := db2.Exec("insert")
+			So(err, ShouldBeNil)
+			// the fake can't run them without preparing, so each is counted once
+			query := r2.NowSeries("op", SQLQuery)[0]
+			So(query.Requests, ShouldEqual, 1)
+			So(query.Errors, ShouldEqual, 1)
+			exec := r2.NowSeries("op", SQLExec)[0]
+			So(exec.Requests, ShouldEqual, 1)
+			So(exec.Errors, ShouldEqual, 0)
+		})
+
+		Convey("Column types and further result sets come from the driver", func() {
+			rows, err := db.Query("rows 2 3")
+			So(err, ShouldBeNil)
+			types, err := rows.ColumnTypes()
+			So(err, ShouldBeNil)
+			So(types[0].DatabaseTypeName(), ShouldEqual, "INTEGER")
+			var counts []int
+			for {
+				n := 0
+				for rows.Next() {
+					n++
+				}
+				counts = append(counts, n)
+				if !rows.NextResultSet() {
+					break
+				}
+			}
+			So(rows.Close(), ShouldBeNil)
+			So(counts, ShouldResemble, []int{2, 3})
+			So(op(SQLQuery).Requests, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a driver that runs statements directly, registered through the wrapper", t, func() {
+		r := NewRegistry().New("db")
+		name := "red-fake-" + strconv.Itoa(len(sql.Drivers()))
+		sql.Register(name, WrapDriver(&fakeDriver{fast: true}, r, SQLOptions{}))
+
+		Convey("Nothing is prepared, and failed connections are errors", func() {
+			db, err := sql.Open(name, "")
+			So(err, ShouldBeNil)
+			defer db.Close()
+			_, err = db.Exec("insert")
+			So(err, ShouldBeNil)
+			So(r.NowSeries("op", SQLExec)[0].Requests, ShouldEqual, 1)
+			So(r.NowSeries("op", SQLPrepare), ShouldBeEmpty)
+
+			// the driver's own ErrSkip isn't counted by default
+			_, err = db.Exec("skip")
+			So(err, ShouldBeNil)
+			So(r.NowSeries("op", SQLExec)[0].Requests, ShouldEqual, 2)
+			So(r.NowSeries("op", SQLExec)[0].Errors, ShouldEqual, 0)
+
+			bad, err := sql.Open(name, "fail")
+			So(err, ShouldBeNil)
+			defer bad.Close()
+			So(bad.Ping(), ShouldNotBeNil)
+			So(r.NowSeries("op", SQLConnect)[0].Errors, ShouldBeGreaterThan, 0)
+		})
+	})
+}
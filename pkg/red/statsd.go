@@ -0,0 +1,210 @@
+package red
+
+// statsd pushes Reds to a StatsD or DogStatsD agent, for hosts that
+// run one instead of a scraper.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsDOptions are the choices for a StatsD exporter. The zero value
+// pushes plain StatsD to a local agent every ten seconds.
+type StatsDOptions struct {
+	Address   string        // host:port of the agent, by default 127.0.0.1:8125
+	Prefix    string        // put in front of every metric name, as in "myapp."
+	Interval  time.Duration // how often Run pushes, by default 10 seconds
+	MTU       int           // the largest packet to send, by default 1432 bytes
+	DogStatsD bool          // send labels as DogStatsD tags, not in the name
+}
+
+// StatsDExporter pushes the change in a Red since its last push:
+// requests and errors as counters, one of each per series, and, if the
+// Red's Timing is BusyTime or CPUTime, the time spent as a timer. The
+// Duration of a WallClock Red is just the time between pushes, which
+// a timer would show as a latency, so it isn't sent.
+type StatsDExporter struct {
+	r    *Red
+	opts StatsDOptions
+	conn net.Conn
+	last map[string]*Red // the previous push, by labels.key()
+}
+
+// NewStatsDExporter creates an exporter for r, and its UDP socket
+func NewStatsDExporter(r *Red, opts StatsDOptions) (*StatsDExporter, error) {
+	if r == nil || r.inst == nil {
+		return nil, fmt.Errorf("r is nil, please call Start() first")
+	}
+	if opts.Address == "" {
+		opts.Address = "127.0.0.1:8125"
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.MTU <= 0 {
+		opts.MTU = 1432
+	}
+	conn, err := net.Dial("udp", opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("can't create a statsd socket for %q, %w", opts.Address, err)
+	}
+	return &StatsDExporter{
+		r:    r,
+		opts: opts,
+		conn: conn,
+		last: make(map[string]*Red),
+	}, nil
+}
+
+// Run pushes every interval until ctx is done, then pushes once more
+// and closes the socket. Failed pushes are logged, and retried with
+// the next interval's.
+func (e *StatsDExporter) Run(ctx context.Context) error {
+	tick := time.NewTicker(e.opts.Interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := e.Push(); err != nil {
+				log.Printf("statsd exporter for %q: push failed, will retry. Message was %q\n", e.r.Name(), err)
+			}
+		case <-ctx.Done():
+			err := e.Push()
+			if cerr := e.conn.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+	}
+}
+
+// Push sends the changes since the last push, in as few packets as fit
+// in the MTU. The first push sends everything since Start(). If sending
+// fails, the next push sends the changes that weren't sent too.
+func (e *StatsDExporter) Push() error {
+	var lines []string
+	var pushed []statsdSeries
+	timed := e.r.inst.timing != WallClock
+
+	for _, now := range e.r.NowSeries() {
+		key := now.Labels.key()
+		delta := counterDelta(now, e.last[key])
+		if !timed {
+			delta.Duration = 0
+		}
+		if delta.Requests == 0 && delta.Errors == 0 && delta.Duration == 0 {
+			continue
+		}
+		name, tags := e.opts.Prefix+dottedPath(now.Name(), now.Labels), ""
+		if e.opts.DogStatsD {
+			name, tags = e.opts.Prefix+dottedPath(now.Name(), nil), dogTags(now.Labels)
+		}
+		lines = append(lines,
+			fmt.Sprintf("%s.requests:%d|c%s", name, delta.Requests, tags),
+			fmt.Sprintf("%s.errors:%d|c%s", name, delta.Errors, tags))
+		if timed {
+			lines = append(lines, fmt.Sprintf("%s.duration:%s|ms%s", name,
+				strconv.FormatFloat(float64(delta.Duration)/float64(time.Millisecond), 'f', -1, 64), tags))
+		}
+		pushed = append(pushed, statsdSeries{key, now, len(lines)})
+	}
+	n, err := e.send(lines)
+	// the series whose lines were all sent are the new last push
+	for _, p := range pushed {
+		if p.end <= n {
+			e.last[p.key] = p.now
+		}
+	}
+	return err
+}
+
+// statsdSeries is a series in a push, and where its lines end
+type statsdSeries struct {
+	key string
+	now *Red
+	end int
+}
+
+// send batches lines into packets no bigger than the MTU. A line that
+// is bigger on its own is sent alone, and left to the network. It
+// returns how many lines were sent before any error.
+func (e *StatsDExporter) send(lines []string) (int, error) {
+	var packet strings.Builder
+	var sent, pending int
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > e.opts.MTU {
+			if _, err := e.conn.Write([]byte(packet.String())); err != nil {
+				return sent, err
+			}
+			sent, pending = sent+pending, 0
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+		pending++
+	}
+	if packet.Len() > 0 {
+		if _, err := e.conn.Write([]byte(packet.String())); err != nil {
+			return sent, err
+		}
+	}
+	return sent + pending, nil
+}
+
+// counterDelta returns now minus last. If there's no last, or a count went
+// down because the Red was restarted, the change is all of now.
+func counterDelta(now, last *Red) Red {
+	if last == nil || now.Requests < last.Requests || now.Errors < last.Errors || now.Duration < last.Duration {
+		return *now
+	}
+	return Red{
+		Requests: now.Requests - last.Requests,
+		Errors:   now.Errors - last.Errors,
+		Duration: now.Duration - last.Duration,
+	}
+}
+
+// dogTags converts labels into DogStatsD tags, as in |#endpoint:/upload
+func dogTags(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	tags := make([]string, len(labels))
+	for i, l := range labels {
+		tags[i] = tagEscaper.Replace(l.Name) + ":" + tagEscaper.Replace(l.Value)
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+// tagEscaper replaces the characters that separate DogStatsD tags and fields
+var tagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// dottedPath builds a metric path from a Red's name and its labels'
+// names and values, as in model_uploads.endpoint._upload
+func dottedPath(name string, labels Labels) string {
+	parts := []string{pathPart(name)}
+	for _, l := range labels {
+		parts = append(parts, pathPart(l.Name), pathPart(l.Value))
+	}
+	return strings.Join(parts, ".")
+}
+
+// pathPart replaces the characters that mean something in a dotted path
+func pathPart(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return pathEscaper.Replace(s)
+}
+
+// pathEscaper replaces separators, whitespace and StatsD's : and |
+var pathEscaper = strings.NewReplacer(".", "_", "/", "_", " ", "_", "\t", "_", "\n", "_", ":", "_", "|", "_", "@", "_", "#", "_")
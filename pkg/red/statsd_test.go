@@ -0,0 +1,126 @@
+package red
+
+// statsd_test is GoConvey tests of the StatsD exporter, against a local UDP listener
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen starts a UDP listener, and returns it and a function that
+// reads the packets it receives until none arrive for a little while
+func listen() (net.PacketConn, func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	return conn, func() []string {
+		var packets []string
+		buf := make([]byte, 65536)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return packets
+			}
+			packets = append(packets, string(buf[:n]))
+		}
+	}
+}
+
+// TestStatsDExporter confirms the exporter sends deltas, tags and batches
+func TestStatsDExporter(t *testing.T) {
+	Convey("Given a red and a statsd exporter for it", t, func() {
+		conn, read := listen()
+		defer conn.Close()
+		r := NewRegistry().NewWithOptions("uploads", Options{Timing: BusyTime})
+		e, err := NewStatsDExporter(r, StatsDOptions{Address: conn.LocalAddr().String(), Prefix: "myapp."})
+		So(err, ShouldBeNil)
+		_ = r.Add(REQUESTS, 5)
+		_ = r.Add(ERRORS, 2)
+
+		Convey("The first push sends the counts since Start()", func() {
+			So(e.Push(), ShouldBeNil)
+			packets := read()
+			So(len(packets), ShouldEqual, 1)
+			So(packets[0], ShouldEqual, "myapp.uploads.requests:5|c\nmyapp.uploads.errors:2|c\nmyapp.uploads.duration:0|ms")
+
+			Convey("And later pushes send only the changes", func() {
+				_ = r.Add(REQUESTS, 1)
+				So(e.Push(), ShouldBeNil)
+				So(read(), ShouldResemble, []string{"myapp.uploads.requests:1|c\nmyapp.uploads.errors:0|c\nmyapp.uploads.duration:0|ms"})
+				So(e.Push(), ShouldBeNil)
+				So(read(), ShouldBeEmpty)
+			})
+
+			Convey("And a restart doesn't send negative counts", func() {
+				r.Start()
+				_ = r.Add(REQUESTS, 1)
+				So(e.Push(), ShouldBeNil)
+				So(read()[0], ShouldStartWith, "myapp.uploads.requests:1|c\n")
+			})
+		})
+
+		Convey("A failed push is sent again with the next one", func() {
+			good := e.conn
+			bad, _ := net.Dial("udp", conn.LocalAddr().String())
+			_ = bad.Close()
+			e.conn = bad
+			So(e.Push(), ShouldNotBeNil)
+			e.conn = good
+			_ = r.Add(REQUESTS, 1)
+			So(e.Push(), ShouldBeNil)
+			So(read(), ShouldResemble, []string{"myapp.uploads.requests:6|c\nmyapp.uploads.errors:2|c\nmyapp.uploads.duration:0|ms"})
+		})
+
+		Convey("A red timed by the wall clock sends no duration", func() {
+			wall := NewRegistry().New("downloads")
+			_ = wall.Add(REQUESTS, 1)
+			w, err := NewStatsDExporter(wall, StatsDOptions{Address: conn.LocalAddr().String()})
+			So(err, ShouldBeNil)
+			So(w.Push(), ShouldBeNil)
+			So(read(), ShouldResemble, []string{"downloads.requests:1|c\ndownloads.errors:0|c"})
+		})
+
+		Convey("Labels go in the path, or in DogStatsD tags", func() {
+			_ = r.With("endpoint", "/upload").Add(REQUESTS, 1)
+			So(e.Push(), ShouldBeNil)
+			So(strings.Join(read(), "\n"), ShouldContainSubstring, "myapp.uploads.endpoint._upload.requests:1|c")
+
+			dog, _ := NewStatsDExporter(r, StatsDOptions{Address: conn.LocalAddr().String(), DogStatsD: true})
+			So(dog.Push(), ShouldBeNil)
+			So(strings.Join(read(), "\n"), ShouldContainSubstring, "uploads.requests:1|c|#endpoint:/upload")
+		})
+
+		Convey("Packets are batched up to the MTU", func() {
+			small, _ := NewStatsDExporter(r, StatsDOptions{Address: conn.LocalAddr().String(), MTU: 64})
+			for _, endpoint := range []string{"/a", "/b", "/c"} {
+				_ = r.With("endpoint", endpoint).Add(REQUESTS, 1)
+			}
+			So(small.Push(), ShouldBeNil)
+			packets := read()
+			So(len(packets), ShouldBeGreaterThan, 4)
+			for _, p := range packets {
+				So(len(p), ShouldBeLessThanOrEqualTo, 64)
+			}
+		})
+
+		Convey("Run pushes on the interval, and once more when it's stopped", func() {
+			fast, _ := NewStatsDExporter(r, StatsDOptions{Address: conn.LocalAddr().String(), Interval: 20 * time.Millisecond})
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- fast.Run(ctx) }()
+			time.Sleep(50 * time.Millisecond)
+			_ = r.Begin().End(nil)
+			cancel()
+			So(<-done, ShouldBeNil)
+			all := strings.Join(read(), "\n")
+			So(all, ShouldContainSubstring, "uploads.requests:5|c")
+			So(all, ShouldContainSubstring, "uploads.requests:1|c")
+		})
+	})
+}
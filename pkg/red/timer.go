@@ -0,0 +1,58 @@
+package red
+
+// timer measures transactions, rather than wall-clock time, as
+// described in "Transactions Times" in Red.md
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timer times one transaction, from Begin() to End()
+type Timer struct {
+	r     *Red
+	begun time.Time
+}
+
+// Begin starts timing a transaction, as in
+//
+//	t := r.Begin()
+//	err := doSomething()
+//	t.End(err)
+func (r *Red) Begin() Timer {
+	return Timer{r: r, begun: time.Now()}
+}
+
+// End counts the transaction as one request, and as an error too if err
+// isn't nil. If the Red was created with Timing: BusyTime, the time since
+// Begin() is added to its Duration, so Duration/Requests is the mean
+// service time. Like a batched Add, it doesn't wait for the worker.
+func (t Timer) End(err error) error {
+	r := t.r
+	if r == nil || r.inst == nil {
+		return fmt.Errorf("r is nil, please call Start() first")
+	}
+	var errors int64
+	if err != nil {
+		errors = 1
+	}
+	elapsed := int64(time.Since(t.begun))
+
+	switch {
+	case r.inst.isClosed():
+		return ErrClosed
+	case r.batch != nil:
+		r.batch.deltas.add(REQUESTS, 1)
+		r.batch.deltas.add(ERRORS, errors)
+		r.batch.deltas.add(DURATION, elapsed)
+		return r.batch.added()
+	case r.inst.backend == Sharded:
+		shards := r.target().shards
+		shards.add(REQUESTS, 1)
+		shards.add(ERRORS, errors)
+		shards.add(DURATION, elapsed)
+		return nil
+	default:
+		return r.inst.do(msg{operation: end, series: r.ser, counts: deltas{1, errors, elapsed}})
+	}
+}
@@ -0,0 +1,51 @@
+package red
+
+// timer_test is GoConvey tests of per-transaction timing
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// TestTimer confirms End counts requests and errors, and sums busy time
+func TestTimer(t *testing.T) {
+	for _, backend := range []Backend{Channel, Mutex, Sharded} {
+		Convey(fmt.Sprintf("Given a busy-time red with the %s backend", backend), t, func() {
+			r := NewWithOptions("timer-"+backend.String(), Options{Backend: backend, Timing: BusyTime}).Start()
+
+			Convey("Two transactions, one failing, are counted once each", func() {
+				for _, err := range []error{nil, errors.New("failed")} {
+					tm := r.Begin()
+					time.Sleep(10 * time.Millisecond)
+					So(tm.End(err), ShouldBeNil)
+				}
+				now := r.Now()
+				So(now.Requests, ShouldEqual, 2)
+				So(now.Errors, ShouldEqual, 1)
+
+				Convey("And Duration is the time spent in them, not since Start()", func() {
+					So(now.Duration, ShouldBeGreaterThanOrEqualTo, 20*time.Millisecond)
+					So(now.Duration, ShouldBeLessThan, time.Since(now.StartTime))
+				})
+			})
+		})
+	}
+
+	Convey("Given a wall-clock red, End still counts the transaction", t, func() {
+		r := New("timer-wall").Start()
+		b, _ := r.Batched(10, time.Hour)
+		_ = b.Begin().End(nil)
+		_ = r.Begin().End(errors.New("failed"))
+		now := r.Now()
+		So(now.Requests, ShouldEqual, 2)
+		So(now.Errors, ShouldEqual, 1)
+	})
+
+	Convey("Given a nil red, End returns an error", t, func() {
+		var r *Red
+		So(r.Begin().End(nil), ShouldNotBeNil)
+	})
+}
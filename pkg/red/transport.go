@@ -0,0 +1,82 @@
+package red
+
+// transport counts a client's outbound requests, errors and latencies,
+// so each service it depends on has its own series.
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RoundTripper is an http.RoundTripper that counts each call in the
+// series of a Red labelled with the host it went to. Failures, including
+// timeouts, are errors, as are responses whose status IsError says are.
+type RoundTripper struct {
+	Base    http.RoundTripper     // does the calls, by default http.DefaultTransport
+	IsError func(status int) bool // whether a status is an error, by default status >= 500
+
+	r       *Red
+	handles sync.Map // from host to the series' handle
+}
+
+// Transport returns a RoundTripper that counts base's calls in r, as in
+//
+//	client := &http.Client{Transport: red.Transport(nil, r), Timeout: 10 * time.Second}
+//
+// The latency of a call is the time until its response's headers arrive,
+// so reading the body isn't included.
+func Transport(base http.RoundTripper, r *Red) *RoundTripper {
+	return &RoundTripper{Base: base, r: r}
+}
+
+// RoundTrip does the call, and counts it
+func (t *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base()
+	series := t.series(req.URL.Host)
+	timer := series.Begin()
+
+	resp, err := base.RoundTrip(req)
+
+	failure := err
+	if err == nil && t.isError(resp.StatusCode) {
+		failure = fmt.Errorf("%s returned status %d", req.URL.Host, resp.StatusCode)
+	}
+	_ = series.Observe(time.Since(timer.begun))
+	_ = timer.End(failure)
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the base
+// transport, if it has any, so http.Client.CloseIdleConnections reaches it
+func (t *RoundTripper) CloseIdleConnections() {
+	if c, ok := t.base().(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// base returns Base, or the default
+func (t *RoundTripper) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// isError applies IsError, or the default rule
+func (t *RoundTripper) isError(status int) bool {
+	if t.IsError != nil {
+		return t.IsError(status)
+	}
+	return status >= 500
+}
+
+// series returns the handle for host, creating it if need be
+func (t *RoundTripper) series(host string) *Red {
+	if h, ok := t.handles.Load(host); ok {
+		return h.(*Red)
+	}
+	h, _ := t.handles.LoadOrStore(host, t.r.With("host", host))
+	return h.(*Red)
+}
@@ -0,0 +1,109 @@
+package red
+
+// transport_test is GoConvey tests of the client-side RoundTripper
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestTransport confirms calls are counted per host, with failures, timeouts and statuses as errors
+func TestTransport(t *testing.T) {
+	Convey("Given a client with an instrumented transport, and a server", t, func() {
+		r := NewRegistry().NewWithOptions("outbound", Options{Timing: BusyTime})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/slow":
+				time.Sleep(200 * time.Millisecond)
+			case "/broken":
+				w.WriteHeader(http.StatusBadGateway)
+			case "/missing":
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+		host := server.Listener.Addr().String()
+		rt := Transport(nil, r)
+		client := &http.Client{Transport: rt, Timeout: 50 * time.Millisecond}
+		call := func(path string) error {
+			resp, err := client.Get(server.URL + path)
+			if err != nil {
+				return err
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			return resp.Body.Close()
+		}
+		series := func() *Red {
+			reds := r.NowSeries("host", host)
+			if len(reds) != 1 {
+				return &Red{}
+			}
+			return reds[0]
+		}
+
+		Convey("Successful calls are counted, with their latencies, in the host's series", func() {
+			So(call("/"), ShouldBeNil)
+			So(call("/missing"), ShouldBeNil)
+			s := series()
+			So(s.Requests, ShouldEqual, 2)
+			So(s.Errors, ShouldEqual, 0)
+			So(s.Latency.Count, ShouldEqual, 2)
+			So(s.Duration, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("5xx responses are errors, and the rule can be changed", func() {
+			So(call("/broken"), ShouldBeNil)
+			So(series().Errors, ShouldEqual, 1)
+			rt.IsError = func(status int) bool { return status >= 400 }
+			So(call("/missing"), ShouldBeNil)
+			So(series().Errors, ShouldEqual, 2)
+		})
+
+		Convey("Timeouts are errors", func() {
+			err := call("/slow")
+			So(err, ShouldNotBeNil)
+			So(err.(*url.Error).Timeout(), ShouldBeTrue)
+			So(series().Requests, ShouldEqual, 1)
+			So(series().Errors, ShouldEqual, 1)
+		})
+
+		Convey("Transport failures are errors, in their own host's series", func() {
+			addr := server.Listener.Addr().String()
+			server.Close()
+			_, err := client.Get("http://" + addr + "/")
+			So(err, ShouldNotBeNil)
+			So(series().Errors, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a client whose transport wraps one with idle connections", t, func() {
+		base := &idleTransport{}
+		client := &http.Client{Transport: Transport(base, NewRegistry().New("idle"))}
+
+		Convey("Closing the client's idle connections closes the base's", func() {
+			client.CloseIdleConnections()
+			So(base.closed, ShouldEqual, 1)
+		})
+
+		Convey("And a base without any is left alone", func() {
+			client.Transport = Transport(struct{ http.RoundTripper }{base}, nil)
+			So(client.CloseIdleConnections, ShouldNotPanic)
+		})
+	})
+}
+
+// idleTransport counts the calls of CloseIdleConnections
+type idleTransport struct {
+	http.RoundTripper
+	closed int
+}
+
+// CloseIdleConnections counts the call
+func (t *idleTransport) CloseIdleConnections() {
+	t.closed++
+}
@@ -0,0 +1,242 @@
+package red
+
+// wire is the text a server sends redstat: one self-describing line,
+// with a version, so a client can tell a restarted server from a
+// healthy one, and so neither has to guess at the other's format.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// WireVersion is the version of the format Sample.MarshalText writes
+const WireVersion = 1
+
+// WireContentType is the content type of the wire format
+const WireContentType = "text/vnd.red; version=1; charset=utf-8"
+
+// InstanceID identifies this server in samples. It's the host name,
+// unless it's set before anything is served.
+var InstanceID = hostname()
+
+// Epoch is when this process started, so a client can tell a restart
+// of a server from a reset of its counters
+var Epoch = time.Now()
+
+// Sample is one reading of a Red, as a server sends it. In the wire
+// format it's a line like
+//
+//	red/1 instance=web1 epoch=2021-12-24T09:42:26.422-05:00 seq=42 time=2021-12-24T17:33:43.487-05:00
+//	name=uploads start=2021-12-24T09:42:47.055-05:00 requests=45225 errors=0 duration=1563.562311863
+//
+// all on one line, with durations in seconds and times in RFC 3339. A
+// Red whose counts were restored from a checkpoint also has a restored=
+// time, so a client can tell they weren't counted from zero.
+// The legacy comma format, as in 3, 1, 3.000667s, is read as a Sample
+// with Version 0 and only the counts set.
+type Sample struct {
+	Version  int       // WireVersion, or 0 for the comma format
+	Instance string    // the server's InstanceID
+	Epoch    time.Time // when the server's process started
+	Sequence uint64    // counts the samples the server has taken of the Red, from 1
+	Time     time.Time // when the server took it
+	Name     string    // the name of the Red
+	Red      *Red      // its counts, duration and start time
+	Restored time.Time // when the checkpoint its counts were restored from was saved, or zero
+}
+
+// Sample takes a Now() of r, numbered in sequence, for a server to send
+func (r *Red) Sample() Sample {
+	return r.sample(r.Now())
+}
+
+// sample numbers now, which was just taken from r
+func (r *Red) sample(now *Red) Sample {
+	var seq uint64
+	var restored time.Time
+	if r != nil && r.inst != nil {
+		seq = atomic.AddUint64(&r.inst.seq, 1)
+		ser := r.ser
+		if ser == nil {
+			ser = r.inst.root
+		}
+		restored = ser.restoredAt()
+	}
+	return Sample{
+		Version:  WireVersion,
+		Instance: InstanceID,
+		Epoch:    Epoch,
+		Sequence: seq,
+		Time:     time.Now(),
+		Name:     r.Name(),
+		Red:      now,
+		Restored: restored,
+	}
+}
+
+// String converts s into its line in the wire format, without a newline
+func (s Sample) String() string {
+	b, err := s.MarshalText()
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// MarshalText converts s into the wire format. A Sample read from the
+// comma format is written back in it.
+func (s Sample) MarshalText() ([]byte, error) {
+	if s.Red == nil {
+		return nil, fmt.Errorf("sample has no Red, please call Sample() to get one")
+	}
+	if s.Version == 0 {
+		return []byte(fmt.Sprintf("%d, %d, %ss", s.Red.Requests, s.Red.Errors, seconds(s.Red.Duration))), nil
+	}
+	var times [4]string
+	for i, t := range []time.Time{s.Epoch, s.Time, s.Red.StartTime, s.Restored} {
+		var err error
+		if times[i], err = timestamp(t); err != nil {
+			return nil, err
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "red/%d instance=%s", WireVersion, wireValue(s.Instance))
+	if times[0] != "" {
+		fmt.Fprintf(&b, " epoch=%s", times[0])
+	}
+	fmt.Fprintf(&b, " seq=%d", s.Sequence)
+	if times[1] != "" {
+		fmt.Fprintf(&b, " time=%s", times[1])
+	}
+	fmt.Fprintf(&b, " name=%s", wireValue(s.Name))
+	if times[2] != "" {
+		fmt.Fprintf(&b, " start=%s", times[2])
+	}
+	if times[3] != "" {
+		fmt.Fprintf(&b, " restored=%s", times[3])
+	}
+	fmt.Fprintf(&b, " requests=%d errors=%d duration=%s", s.Red.Requests, s.Red.Errors, seconds(s.Red.Duration))
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText reads a line in the wire format, or in the comma format
+func (s *Sample) UnmarshalText(text []byte) error {
+	line := strings.TrimSpace(string(text))
+	if !strings.HasPrefix(line, "red/") {
+		return s.unmarshalComma(line)
+	}
+
+	version, rest, _ := strings.Cut(strings.TrimPrefix(line, "red/"), " ")
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return fmt.Errorf("can't read the version of %q, %w", line, err)
+	}
+	if v != WireVersion {
+		return fmt.Errorf("can't read version %d of the wire format, only %d", v, WireVersion)
+	}
+	fields, err := wireFields(rest)
+	if err != nil {
+		return fmt.Errorf("can't read %q, %w", line, err)
+	}
+	for _, key := range []string{"requests", "errors", "duration"} {
+		if _, ok := fields[key]; !ok {
+			return fmt.Errorf("can't read %q, it has no %s", line, key)
+		}
+	}
+
+	tmp := Sample{Version: v, Instance: fields["instance"], Name: fields["name"], Red: &Red{}}
+	if seq, ok := fields["seq"]; ok {
+		if tmp.Sequence, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return fmt.Errorf("can't read the seq of %q, %w", line, err)
+		}
+	}
+	for key, to := range map[string]*time.Time{"epoch": &tmp.Epoch, "time": &tmp.Time,
+		"start": &tmp.Red.StartTime, "restored": &tmp.Restored} {
+		if *to, err = parseTimestamp(fields[key]); err != nil {
+			return fmt.Errorf("can't read the %s of %q, %w", key, line, err)
+		}
+	}
+	if tmp.Red.Requests, err = strconv.ParseInt(fields["requests"], 10, 64); err != nil {
+		return fmt.Errorf("can't read the requests of %q, %w", line, err)
+	}
+	if tmp.Red.Errors, err = strconv.ParseInt(fields["errors"], 10, 64); err != nil {
+		return fmt.Errorf("can't read the errors of %q, %w", line, err)
+	}
+	if tmp.Red.Duration, err = parseSeconds(json.Number(fields["duration"])); err != nil {
+		return fmt.Errorf("can't read the duration of %q, %w", line, err)
+	}
+	*s = tmp
+	return nil
+}
+
+// unmarshalComma reads the comma format, as in 3, 1, 3.000667s, with or
+// without the s, and ignores any latencies after it
+func (s *Sample) unmarshalComma(line string) error {
+	parts := strings.SplitN(line, ",", 4)
+	if len(parts) < 3 {
+		return fmt.Errorf("can't read a Red from %q, it has %d fields, not 3", line, len(parts))
+	}
+	red := &Red{}
+	var err error
+	if red.Requests, err = strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64); err != nil {
+		return fmt.Errorf("can't read the requests of %q, %w", line, err)
+	}
+	if red.Errors, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64); err != nil {
+		return fmt.Errorf("can't read the errors of %q, %w", line, err)
+	}
+	duration := strings.TrimSuffix(strings.TrimSpace(parts[2]), "s")
+	if red.Duration, err = parseSeconds(json.Number(duration)); err != nil {
+		return fmt.Errorf("can't read the duration of %q, %w", line, err)
+	}
+	*s = Sample{Red: red}
+	return nil
+}
+
+// wireFields splits key=value pairs, where a value may be quoted
+func wireFields(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok || key == "" || strings.Contains(key, " ") {
+			return nil, fmt.Errorf("expected key=value at %q", s)
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted value for %s, %w", key, err)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+		fields[key] = value
+		s = rest
+	}
+	return fields, nil
+}
+
+// wireValue quotes a value if it's empty or has spaces, quotes or
+// anything unprintable in it
+func wireValue(s string) string {
+	if s == "" || strings.ContainsAny(s, ` ="\`) || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// hostname is the default InstanceID
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "unknown"
+	}
+	return name
+}
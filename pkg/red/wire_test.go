@@ -0,0 +1,96 @@
+package red
+
+// wire_test is GoConvey tests of the wire format
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWire confirms samples are written and read back, and the comma format is read
+func TestWire(t *testing.T) {
+	Convey("Given a red", t, func() {
+		r := NewRegistry().NewWithOptions("model uploads", Options{Timing: BusyTime})
+		_ = r.Add(REQUESTS, 45225)
+		_ = r.Add(ERRORS, 2)
+
+		Convey("Samples are numbered in sequence, and stamped with the instance and epoch", func() {
+			first, second := r.Sample(), r.Sample()
+			So(first.Sequence, ShouldEqual, 1)
+			So(second.Sequence, ShouldEqual, 2)
+			So(first.Instance, ShouldEqual, InstanceID)
+			So(first.Epoch, ShouldEqual, Epoch)
+			So(first.Name, ShouldEqual, "model uploads")
+			So(first.Red.Requests, ShouldEqual, 45225)
+		})
+
+		Convey("A sample is one line, and reads back the same", func() {
+			s := r.Sample()
+			s.Instance = "web 1"
+			s.Red.Duration = 1563562311863
+			line := s.String()
+			So(line, ShouldStartWith, `red/1 instance="web 1" epoch=`)
+			So(line, ShouldContainSubstring, ` seq=1 time=`)
+			So(line, ShouldContainSubstring, ` name="model uploads" start=`)
+			So(line, ShouldEndWith, ` requests=45225 errors=2 duration=1563.562311863`)
+			So(strings.Count(line, "\n"), ShouldEqual, 0)
+
+			var back Sample
+			So(back.UnmarshalText([]byte(line+"\n")), ShouldBeNil)
+			So(back.Version, ShouldEqual, WireVersion)
+			So(back.Instance, ShouldEqual, "web 1")
+			So(back.Epoch.Equal(s.Epoch), ShouldBeTrue)
+			So(back.Sequence, ShouldEqual, 1)
+			So(back.Time.Equal(s.Time), ShouldBeTrue)
+			So(back.Name, ShouldEqual, "model uploads")
+			So(back.Red.StartTime.Equal(s.Red.StartTime), ShouldBeTrue)
+			So(back.Red.String(), ShouldEqual, s.Red.String())
+			So(back.Red.Duration, ShouldEqual, 1563562311863)
+			So(back.Restored.IsZero(), ShouldBeTrue)
+
+			s.Restored = s.Time.Add(-time.Hour)
+			line = s.String()
+			So(line, ShouldContainSubstring, ` restored=`)
+			So(back.UnmarshalText([]byte(line)), ShouldBeNil)
+			So(back.Restored.Equal(s.Restored), ShouldBeTrue)
+		})
+
+		Convey("Unknown keys are ignored, and missing counts or other versions are errors", func() {
+			var s Sample
+			So(s.UnmarshalText([]byte("red/1 requests=1 errors=0 duration=2.5 colour=red")), ShouldBeNil)
+			So(s.Red.Duration, ShouldEqual, 2500*time.Millisecond)
+			So(s.UnmarshalText([]byte("red/1 requests=1 duration=2.5")), ShouldNotBeNil)
+			So(s.UnmarshalText([]byte("red/2 requests=1 errors=0 duration=2.5")), ShouldNotBeNil)
+			So(s.UnmarshalText([]byte(`red/1 name="unterminated requests=1`)), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given the comma format", t, func() {
+		Convey("It's read with sub-second durations, with or without the s", func() {
+			var s Sample
+			So(s.UnmarshalText([]byte("3, 1, 3.000667s")), ShouldBeNil)
+			So(s.Version, ShouldEqual, 0)
+			So(s.Red.Requests, ShouldEqual, 3)
+			So(s.Red.Errors, ShouldEqual, 1)
+			So(s.Red.Duration, ShouldEqual, 3000667*time.Microsecond)
+			So(s.String(), ShouldEqual, "3, 1, 3.000667s")
+			So(s.UnmarshalText([]byte("0, 0, 42.0")), ShouldBeNil)
+			So(s.Red.Duration, ShouldEqual, 42*time.Second)
+		})
+
+		Convey("Latencies after the duration are ignored", func() {
+			var s Sample
+			So(s.UnmarshalText([]byte("3, 1, 3.5s, p50 0.1s, p90 0.2s, p99 0.3s, max 0.4s")), ShouldBeNil)
+			So(s.Red.Duration, ShouldEqual, 3500*time.Millisecond)
+		})
+
+		Convey("Anything else is an error", func() {
+			var s Sample
+			So(s.UnmarshalText([]byte("r is nil, please call Start() first")), ShouldNotBeNil)
+			So(s.UnmarshalText([]byte("3, 1")), ShouldNotBeNil)
+			So(s.UnmarshalText([]byte("3, 1, soon")), ShouldNotBeNil)
+		})
+	})
+}